@@ -21,6 +21,41 @@ import (
 	"google.golang.org/api/serviceusage/v1"
 )
 
+// requiredAPIs returns the APIs that need to be enabled to deploy the services.
+func requiredAPIs(services []service) []string {
+	apis := []string{"run.googleapis.com", "artifactregistry.googleapis.com"}
+	var secrets, serviceAccounts, sql, buckets, remote bool
+	for _, s := range services {
+		for _, e := range s.Env {
+			secrets = secrets || e.Secret
+		}
+		serviceAccounts = serviceAccounts || s.ServiceAccount != nil
+		sql = sql || s.CloudSQL != nil
+		remote = remote || (s.Build.Remote != nil && *s.Build.Remote) || s.Build.Strategy == strategyRemote
+		for _, v := range s.Volumes {
+			secrets = secrets || v.Secret != nil || v.File != nil
+			buckets = buckets || v.CloudStorage != nil
+		}
+	}
+	// the Cloud SQL password is stored as a secret
+	if secrets || sql {
+		apis = append(apis, "secretmanager.googleapis.com")
+	}
+	if serviceAccounts {
+		apis = append(apis, "iam.googleapis.com")
+	}
+	if sql {
+		apis = append(apis, "sqladmin.googleapis.com")
+	}
+	if buckets && !remote {
+		apis = append(apis, "storage.googleapis.com")
+	}
+	if remote {
+		apis = append(apis, remoteBuildAPIs...)
+	}
+	return apis
+}
+
 func enableAPIs(project string, apis []string) error {
 	client, err := serviceusage.NewService(context.TODO())
 	if err != nil {
@@ -18,11 +18,20 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/mail"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/fatih/color"
 
@@ -30,21 +39,214 @@ import (
 )
 
 type env struct {
-	Description string `json:"description"`
-	Value       string `json:"value"`
-	Required    *bool  `json:"required"`
-	Generator   string `json:"generator"`
-	Order       *int   `json:"order"`
+	Description string        `json:"description"`
+	Value       string        `json:"value"`
+	Required    *bool         `json:"required"`
+	Generator   generatorSpec `json:"generator"`
+	Order       *int          `json:"order"`
+	Secret      bool          `json:"secret"`
+	Type        string        `json:"type"`
+	Pattern     string        `json:"pattern"`
+	Enum        []string      `json:"enum"`
+	Min         *float64      `json:"min"`
+	Max         *float64      `json:"max"`
+	Sensitive   bool          `json:"sensitive"`
+	When        string        `json:"when"`
+}
+
+var envTypes = []string{"string", "int", "bool", "url", "email"}
+
+// validate checks that the env var definition is consistent, including its
+// default value.
+func (e env) validate() error {
+	if e.Type != "" {
+		known := false
+		for _, t := range envTypes {
+			known = known || t == e.Type
+		}
+		if !known {
+			return fmt.Errorf("unknown type %q, must be one of: %s", e.Type, strings.Join(envTypes, ", "))
+		}
+	}
+	if e.Pattern != "" {
+		if _, err := regexp.Compile(e.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	if e.Min != nil || e.Max != nil {
+		switch e.Type {
+		case "", "string", "int":
+		default:
+			return fmt.Errorf("min and max are not supported for type %q", e.Type)
+		}
+		if e.Min != nil && e.Max != nil && *e.Min > *e.Max {
+			return errors.New("min can't be greater than max")
+		}
+	}
+	if len(e.Enum) > 0 {
+		if e.Sensitive {
+			return errors.New("enum can't be sensitive")
+		}
+		for _, v := range e.Enum {
+			if err := e.checkValue(v); err != nil {
+				return fmt.Errorf("invalid enum value %q: %v", v, err)
+			}
+		}
+	}
+	if len(templateRefs(e.Value)) == 0 {
+		// templated defaults are checked when prompting
+		v, err := expandTemplate(e.Value, nil)
+		if err != nil {
+			return err
+		}
+		if err := e.check(v); err != nil {
+			return fmt.Errorf("invalid default value %q: %v", e.Value, err)
+		}
+	}
+	return nil
+}
+
+// check validates a value of the env var. Empty values are not checked,
+// required values are enforced separately.
+func (e env) check(v string) error {
+	if v == "" {
+		return nil
+	}
+	if len(e.Enum) > 0 {
+		found := false
+		for _, ev := range e.Enum {
+			found = found || ev == v
+		}
+		if !found {
+			return fmt.Errorf("must be one of: %s", strings.Join(e.Enum, ", "))
+		}
+	}
+	return e.checkValue(v)
+}
+
+// checkValue validates the value against the type, pattern and bounds.
+func (e env) checkValue(v string) error {
+	switch e.Type {
+	case "", "string":
+		if e.Min != nil && float64(len(v)) < *e.Min {
+			return fmt.Errorf("must be at least %v characters", *e.Min)
+		}
+		if e.Max != nil && float64(len(v)) > *e.Max {
+			return fmt.Errorf("must be at most %v characters", *e.Max)
+		}
+	case "int":
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		if e.Min != nil && float64(n) < *e.Min {
+			return fmt.Errorf("must be at least %v", *e.Min)
+		}
+		if e.Max != nil && float64(n) > *e.Max {
+			return fmt.Errorf("must be at most %v", *e.Max)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return errors.New("must be true or false")
+		}
+	case "url":
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.New("must be an absolute URL")
+		}
+	case "email":
+		a, err := mail.ParseAddress(v)
+		if err != nil || a.Address != v {
+			return errors.New("must be an email address")
+		}
+	}
+	if e.Pattern != "" && !regexp.MustCompile(`^(?:`+e.Pattern+`)$`).MatchString(v) {
+		return fmt.Errorf("must match the pattern %s", e.Pattern)
+	}
+	return nil
 }
 
 type options struct {
-	AllowUnauthenticated *bool  `json:"allow-unauthenticated"`
-	Memory               string `json:"memory"`
-	CPU                  string `json:"cpu"`
-	Port                 int    `json:"port"`
-	HTTP2                *bool  `json:"http2"`
-	Concurrency          int    `json:"concurrency"`
-	MaxInstances         int    `json:"max-instances"`
+	AllowUnauthenticated *bool    `json:"allow-unauthenticated"`
+	Memory               string   `json:"memory"`
+	CPU                  string   `json:"cpu"`
+	Port                 int      `json:"port"`
+	HTTP2                *bool    `json:"http2"`
+	Concurrency          int      `json:"concurrency"`
+	MaxInstances         int      `json:"max-instances"`
+	MinInstances         *int     `json:"min-instances"`
+	Timeout              int      `json:"timeout"`
+	CPUBoost             *bool    `json:"cpu-boost"`
+	CPUThrottling        *bool    `json:"cpu-throttling"`
+	Ingress              string   `json:"ingress"`
+	VPCConnector         string   `json:"vpc-connector"`
+	Network              string   `json:"network"`
+	Subnet               string   `json:"subnet"`
+	NetworkTags          []string `json:"network-tags"`
+	VPCEgress            string   `json:"vpc-egress"`
+	SyncEnv              *bool    `json:"sync-env"`
+	Command              []string `json:"command"`
+	Args                 []string `json:"args"`
+	WorkingDir           string   `json:"working-dir"`
+}
+
+var (
+	ingressSettings   = []string{"all", "internal", "internal-and-cloud-load-balancing"}
+	vpcEgressSettings = []string{"private-ranges-only", "all-traffic"}
+)
+
+// maxTimeout is the maximum request timeout of Cloud Run services, in seconds.
+const maxTimeout = 3600
+
+// validate checks the values of the options.
+func (o options) validate() error {
+	if o.MinInstances != nil && *o.MinInstances < 0 {
+		return fmt.Errorf("min-instances must not be negative")
+	}
+	if o.MaxInstances < 0 {
+		return fmt.Errorf("max-instances must not be negative")
+	}
+	if o.MinInstances != nil && o.MaxInstances > 0 && *o.MinInstances > o.MaxInstances {
+		return fmt.Errorf("min-instances (%d) must not be greater than max-instances (%d)", *o.MinInstances, o.MaxInstances)
+	}
+	if o.Timeout < 0 || o.Timeout > maxTimeout {
+		return fmt.Errorf("timeout must be between 1 and %d seconds", maxTimeout)
+	}
+	if o.Ingress != "" && !contains(ingressSettings, o.Ingress) {
+		return fmt.Errorf("ingress must be one of: %s", strings.Join(ingressSettings, ", "))
+	}
+	directVPC := o.Network != "" || o.Subnet != ""
+	if o.VPCConnector != "" && directVPC {
+		return fmt.Errorf("vpc-connector can't be used with network and subnet")
+	}
+	if len(o.NetworkTags) > 0 && !directVPC {
+		return fmt.Errorf("network-tags require a network or subnet")
+	}
+	if o.VPCEgress != "" {
+		if !contains(vpcEgressSettings, o.VPCEgress) {
+			return fmt.Errorf("vpc-egress must be one of: %s", strings.Join(vpcEgressSettings, ", "))
+		}
+		if o.VPCConnector == "" && !directVPC {
+			return fmt.Errorf("vpc-egress requires a vpc-connector, or a network or subnet")
+		}
+	}
+	if len(o.Command) > 0 && o.Command[0] == "" {
+		return fmt.Errorf("command must start with the executable")
+	}
+	if o.WorkingDir != "" && !path.IsAbs(o.WorkingDir) {
+		return fmt.Errorf("working-dir must be an absolute path")
+	}
+	return nil
+}
+
+// contains checks if the list contains the value.
+func contains(list []string, v string) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
 }
 
 type hook struct {
@@ -58,6 +260,42 @@ type buildpacks struct {
 type build struct {
 	Skip       *bool      `json:"skip"`
 	Buildpacks buildpacks `json:"buildpacks"`
+	// Remote builds on Cloud Build instead of locally. When unset, builds
+	// that need docker are remote if docker is unavailable.
+	Remote *bool `json:"remote"`
+	// Strategy forces the builder instead of detecting it.
+	Strategy string `json:"strategy"`
+	// Docker configures the build of the Dockerfile.
+	Docker dockerConfig `json:"docker"`
+}
+
+// validate checks that the build settings don't conflict.
+func (b build) validate() error {
+	if err := validateStrategy(b.Strategy); err != nil {
+		return err
+	}
+	if err := b.Docker.validate(); err != nil {
+		return fmt.Errorf("invalid docker: %w", err)
+	}
+	if b.Docker.isSet() && b.Buildpacks.Builder != "" {
+		return fmt.Errorf("docker and buildpacks can't be used together")
+	}
+	if b.Strategy == "" {
+		return nil
+	}
+	if b.Skip != nil && *b.Skip {
+		return fmt.Errorf("strategy can't be used with skip")
+	}
+	if b.Remote != nil {
+		return fmt.Errorf("remote can't be used with strategy, use strategy %q instead", strategyRemote)
+	}
+	if b.Buildpacks.Builder != "" && b.Strategy != strategyBuildpacks && b.Strategy != strategyRemote {
+		return fmt.Errorf("buildpacks can only be used with strategy %q or %q", strategyBuildpacks, strategyRemote)
+	}
+	if b.Docker.isSet() && b.Strategy != strategyDocker && b.Strategy != strategyRemote {
+		return fmt.Errorf("docker can only be used with strategy %q or %q", strategyDocker, strategyRemote)
+	}
+	return nil
 }
 
 type hooks struct {
@@ -67,12 +305,49 @@ type hooks struct {
 	PostBuild  hook `json:"postbuild"`
 }
 
+// service describes one of the Cloud Run services deployed from an app.json
+// that uses the "services" section.
+type service struct {
+	Name      string         `json:"name"`
+	Dir       string         `json:"dir"`
+	DependsOn []string       `json:"depends-on"`
+	Env       map[string]env `json:"env"`
+	Options   options        `json:"options"`
+	Build     build          `json:"build"`
+	Hooks     hooks          `json:"hooks"`
+
+	// Kind is "service" (the default) or "job".
+	Kind string `json:"kind"`
+	Job  *job   `json:"job"`
+
+	ServiceAccount *serviceAccount `json:"service-account"`
+	CloudSQL       *cloudSQL       `json:"cloudsql"`
+	Volumes        []volume        `json:"volumes"`
+	Sidecars       []sidecar       `json:"sidecars"`
+	Probes         *probes         `json:"probes"`
+}
+
 type appFile struct {
-	Name    string         `json:"name"`
-	Env     map[string]env `json:"env"`
-	Options options        `json:"options"`
-	Build   build          `json:"build"`
-	Hooks   hooks          `json:"hooks"`
+	Name     string         `json:"name"`
+	Env      map[string]env `json:"env"`
+	Options  options        `json:"options"`
+	Build    build          `json:"build"`
+	Hooks    hooks          `json:"hooks"`
+	Services []service      `json:"services"`
+
+	Kind string `json:"kind"`
+	Job  *job   `json:"job"`
+
+	// Labels are set on all the resources created for the app.
+	Labels map[string]string `json:"labels"`
+	// KMSKey encrypts the services, images and secrets of the app.
+	KMSKey string `json:"kms-key"`
+
+	ServiceAccount *serviceAccount `json:"service-account"`
+	CloudSQL       *cloudSQL       `json:"cloudsql"`
+	Volumes        []volume        `json:"volumes"`
+	Sidecars       []sidecar       `json:"sidecars"`
+	Probes         *probes         `json:"probes"`
 
 	// The following are unused variables that are still silently accepted
 	// for compatibility with Heroku app.json files.
@@ -108,22 +383,354 @@ func parseAppFile(r io.Reader) (*appFile, error) {
 		return nil, fmt.Errorf("failed to parse app.json: %+v", err)
 	}
 
+	top := v.service("")
+	if err := parseServiceSettings(top, contextVars); err != nil {
+		return nil, err
+	}
+	if err := validateLabels(v.Labels); err != nil {
+		return nil, err
+	}
+	if err := validateKMSKey(v.KMSKey); err != nil {
+		return nil, err
+	}
+
+	if len(v.Services) > 0 {
+		if len(top.Env) == 0 {
+			top.Env = nil
+		}
+		if !reflect.ValueOf(top).IsZero() {
+			return nil, fmt.Errorf("deployment settings like \"env\", \"options\" and \"build\" must be set on each entry of \"services\" instead of the top level")
+		}
+		if _, err := sortServices(v.Services); err != nil {
+			return nil, err
+		}
+		for _, s := range v.Services {
+			known := contextVars
+			for _, dep := range s.DependsOn {
+				urlEnv := serviceURLEnv(dep)
+				if _, ok := s.Env[urlEnv]; ok {
+					return nil, fmt.Errorf("service %q: env var %s is reserved for the URL of service %q", s.Name, urlEnv, dep)
+				}
+				known = append(known, urlEnv)
+			}
+			if err := parseServiceSettings(s, known); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+		if err := validateDotEnvKeys(v.Services); err != nil {
+			return nil, err
+		}
+	}
+
+	return &v, nil
+}
+
+// parseServiceSettings validates the settings of a service, and applies defaults
+// to its env vars. Besides each other, env vars can refer to the known variables.
+func parseServiceSettings(s service, known []string) error {
+	list := s.Env
+	if sql := s.CloudSQL; sql != nil {
+		if err := sql.validate(); err != nil {
+			return fmt.Errorf("invalid cloudsql: %w", err)
+		}
+		for _, k := range cloudSQLEnvs {
+			if _, ok := list[k]; ok {
+				return fmt.Errorf("env var %q is set from cloudsql and can't be declared", k)
+			}
+		}
+		known = append(append([]string(nil), known...), cloudSQLEnvs...)
+	}
+	if err := parseEnvs(list, known); err != nil {
+		return err
+	}
+	if err := s.Options.validate(); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	if err := s.Build.validate(); err != nil {
+		return fmt.Errorf("invalid build: %w", err)
+	}
+	// the service URL is not known yet when first building it
+	for _, k := range s.Build.Docker.argNames() {
+		for _, ref := range templateRefs(s.Build.Docker.Args[k]) {
+			if _, ok := list[ref]; !ok && (ref == serviceURLVar || !slices.Contains(known, ref)) {
+				return fmt.Errorf("build arg %q refers to unknown variable %q", k, ref)
+			}
+			if confidentialEnv(list, ref) {
+				return fmt.Errorf("build arg %q can't refer to secret variable %q, as build args are shown in the build command and kept in the image", k, ref)
+			}
+		}
+	}
+	if sa := s.ServiceAccount; sa != nil {
+		if err := sa.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateVolumes(s.Volumes); err != nil {
+		return err
+	}
+	if err := validateSidecars(s.Sidecars); err != nil {
+		return err
+	}
+	if p := s.Probes; p != nil {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateKind(s); err != nil {
+		return err
+	}
+	return nil
+}
+
+// contextVars are the variables describing the deployment that env var values
+// can refer to. They are also provided to the hooks.
+var contextVars = []string{"GOOGLE_CLOUD_PROJECT", "GOOGLE_CLOUD_REGION", "K_SERVICE", "IMAGE_URL", "APP_DIR", serviceURLVar}
+
+// serviceURLVar is the variable holding the URL of the service, which is
+// only known once the service is deployed.
+const serviceURLVar = "SERVICE_URL"
+
+// parseEnvs applies defaults to the env var definitions and validates them.
+// Besides each other, env vars can refer to the known variables.
+func parseEnvs(list map[string]env, known []string) error {
 	// make "required" true by default
-	for k, env := range v.Env {
+	for k, env := range list {
 		if env.Required == nil {
 			v := true
 			env.Required = &v
 		}
-		v.Env[k] = env
+		list[k] = env
 	}
 
-	for k, env := range v.Env {
-		if env.Generator == "secret" && env.Value != "" {
-			return nil, fmt.Errorf("env var %q can't have both a value and use the secret generator", k)
+	isKnown := make(map[string]bool)
+	for _, k := range known {
+		isKnown[k] = true
+	}
+	for _, k := range sortedEnvs(list) {
+		env := list[k]
+		if err := env.validate(); err != nil {
+			return fmt.Errorf("env var %q: %w", k, err)
+		}
+		if env.When != "" {
+			if _, err := parseWhen(env.When); err != nil {
+				return fmt.Errorf("env var %q: %w", k, err)
+			}
+		}
+		for _, ref := range envDeps(env) {
+			if _, ok := list[ref]; !ok && !isKnown[ref] {
+				return fmt.Errorf("env var %q refers to unknown variable %q, use $${%s} for a literal ${%s}", k, ref, ref, ref)
+			}
+		}
+		if env.Generator.Type == "" {
+			continue
+		}
+		if env.Value != "" {
+			return fmt.Errorf("env var %q can't have both a value and use the %s generator", k, env.Generator.Type)
 		}
+		if err := env.Generator.validate(); err != nil {
+			return fmt.Errorf("env var %q: %w", k, err)
+		}
+	}
+	if _, err := orderEnvs(list); err != nil {
+		return err
 	}
+	return nil
+}
 
-	return &v, nil
+// envDeps returns the names of the variables the env var refers to.
+func envDeps(e env) []string {
+	out := append(templateRefs(e.Value), templateRefs(e.Generator.Template)...)
+	if e.When != "" {
+		if w, err := parseWhen(e.When); err == nil {
+			out = append(out, w.refs()...)
+		}
+	}
+	return out
+}
+
+// confidentialEnv checks if the value of the variable must not be disclosed:
+// the database password, or an env var of the list that is secret, sensitive,
+// a generated secret, or whose value refers to such a variable.
+func confidentialEnv(list map[string]env, k string) bool {
+	if k == sqlPasswordEnv {
+		return true
+	}
+	e, ok := list[k]
+	if !ok {
+		return false
+	}
+	if e.Secret || e.Sensitive || e.generatesSecret() {
+		return true
+	}
+	for _, ref := range append(templateRefs(e.Value), templateRefs(e.Generator.Template)...) {
+		if ref != k && confidentialEnv(list, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled evaluates the "when" condition of the env var against the values of
+// the variables. Env vars without a condition are always enabled.
+func (e env) enabled(values map[string]string) bool {
+	if e.When == "" {
+		return true
+	}
+	w, err := parseWhen(e.When)
+	if err != nil {
+		return false
+	}
+	return w.eval(func(k string) string { return values[k] })
+}
+
+// orderEnvs returns the keys of the env vars in the order of sortedEnvs,
+// except that env vars come after the env vars they refer to, in their value
+// or their "when" condition, so that the answers they depend on are known.
+func orderEnvs(list map[string]env) ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var out []string
+	var visit func(k string, path []string) error
+	visit = func(k string, path []string) error {
+		e, ok := list[k]
+		if !ok || state[k] == visited {
+			return nil
+		}
+		if state[k] == visiting {
+			return fmt.Errorf("env vars refer to each other: %s", strings.Join(append(path, k), " -> "))
+		}
+		state[k] = visiting
+		for _, ref := range envDeps(e) {
+			if err := visit(ref, append(path, k)); err != nil {
+				return err
+			}
+		}
+		state[k] = visited
+		out = append(out, k)
+		return nil
+	}
+	for _, k := range sortedEnvs(list) {
+		if err := visit(k, nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// services returns the services described by the app file in the order they
+// should be deployed. An app file without a "services" section describes a
+// single service, named defaultName unless "name" is set.
+func (a appFile) services(defaultName string) ([]service, error) {
+	if len(a.Services) == 0 {
+		name := defaultName
+		if a.Name != "" {
+			name = a.Name
+		}
+		return []service{a.service(name)}, nil
+	}
+	return sortServices(a.Services)
+}
+
+// service returns the service described by the top-level settings of the app
+// file.
+func (a appFile) service(name string) service {
+	return service{
+		Name:    name,
+		Env:     a.Env,
+		Options: a.Options,
+		Build:   a.Build,
+		Hooks:   a.Hooks,
+
+		Kind: a.Kind,
+		Job:  a.Job,
+
+		ServiceAccount: a.ServiceAccount,
+		CloudSQL:       a.CloudSQL,
+		Volumes:        a.Volumes,
+		Sidecars:       a.Sidecars,
+		Probes:         a.Probes,
+	}
+}
+
+// sortServices orders the services so that each one comes after the services
+// it depends on, otherwise keeping the order they are declared in.
+func sortServices(list []service) ([]service, error) {
+	byName := make(map[string]service)
+	// the names the services are deployed with, see tryFixServiceName
+	deployedAs := make(map[string]string)
+	for _, s := range list {
+		if s.Name == "" {
+			return nil, errors.New("services must have a name")
+		}
+		if _, ok := byName[s.Name]; ok {
+			return nil, fmt.Errorf("service %q is declared more than once", s.Name)
+		}
+		byName[s.Name] = s
+		name, err := tryFixServiceName(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := deployedAs[name]; ok {
+			return nil, fmt.Errorf("services %q and %q would both be deployed as Cloud Run service %q", other, s.Name, name)
+		}
+		deployedAs[name] = s.Name
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var out []service
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("services have a dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("service %q depends on unknown service %q", name, dep)
+			}
+			if byName[dep].isJob() {
+				return fmt.Errorf("service %q depends on job %q, which has no URL", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		out = append(out, byName[name])
+		return nil
+	}
+	for _, s := range list {
+		if err := visit(s.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// serviceURLEnv returns the name of the environment variable that carries the
+// URL of the named service to the services depending on it.
+func serviceURLEnv(name string) string {
+	return serviceEnvName(name) + "_URL"
+}
+
+// serviceEnvName returns the name the service is deployed with in the form of
+// an env var name, e.g. "MY_API" for "my-api" or "SVC_1_API" for "1_api".
+// sortServices ensures the services of an app.json get distinct ones.
+func serviceEnvName(name string) string {
+	if fixed, err := tryFixServiceName(name); err == nil {
+		name = fixed
+	}
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
 }
 
 // getAppFile returns the parsed app.json in the directory if it exists,
@@ -157,53 +764,221 @@ func rand64String() (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-// takes the envs defined in app.json, and the existing envs and returns the new envs that need to be prompted for
-func needEnvs(list map[string]env, existing map[string]struct{}) map[string]env {
+// takes the envs defined in app.json, the existing env values and the existing envs to update, and returns the envs
+// that need to be prompted for. The existing values become the defaults of the envs to update, except for secrets
+// whose existing value is a reference to the secret.
+func needEnvs(list map[string]env, existing map[string]string, update []string) map[string]env {
+	out := make(map[string]env)
+	for k, e := range list {
+		if _, isPresent := existing[k]; !isPresent {
+			out[k] = e
+		}
+	}
+	for _, k := range update {
+		e, ok := list[k]
+		if !ok {
+			continue
+		}
+		if !e.Secret {
+			e.Value = existing[k]
+			// the existing value replaces the generator, unless the user
+			// clears it to generate a new one
+			if e.Generator.Type != "" && e.Value != "" {
+				e.Generator = generatorSpec{}
+			}
+		}
+		out[k] = e
+	}
+	return out
+}
+
+// existingEnvs returns the keys of the envs defined in app.json that already exist on the service.
+func existingEnvs(list map[string]env, existing map[string]string) []string {
+	var out []string
 	for k := range list {
-		_, isPresent := existing[k]
-		if isPresent {
-			delete(list, k)
+		if _, isPresent := existing[k]; isPresent {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// promptUpdateEnvs asks which of the existing env vars should be prompted for again.
+func promptUpdateEnvs(keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var update []string
+	if err := survey.AskOne(&survey.MultiSelect{
+		Message: "Select the existing environment variables to update, or press Enter to keep them:",
+		Options: keys,
+	}, &update, surveyIconOpts); err != nil {
+		return nil, fmt.Errorf("could not prompt for the environment variables to update: %+v", err)
+	}
+	return update, nil
+}
+
+// promptOrGenerateEnvs prompts for or generates the values of the env vars,
+// and returns them as K=V pairs. vars holds the values that the env vars can
+// refer to besides each other, and answers the values of the env vars that
+// are not prompted for. The env vars that refer to SERVICE_URL while
+// the service doesn't exist yet are returned separately, to be set once it is
+// deployed.
+func promptOrGenerateEnvs(list map[string]env, vars, answers map[string]string) ([]string, map[string]env, error) {
+	useDefaults := false
+	var optional int
+	for _, k := range optionalEnvs(list) {
+		if _, ok := answers[k]; !ok {
+			optional++
+		}
+	}
+	if optional > 0 {
+		if err := survey.AskOne(&survey.Confirm{
+			Default: false,
+			Message: fmt.Sprintf("Use the default values for all %d optional environment variables?", optional),
+		}, &useDefaults, surveyIconOpts); err != nil {
+			return nil, nil, fmt.Errorf("could not prompt for confirmation: %+v", err)
 		}
 	}
 
-	return list
+	list, ask := answerEnvs(list, answers, func(k string, e env) (string, error) {
+		if useDefaults && !e.isRequired() {
+			return e.Value, nil
+		}
+		return promptEnv(k, e)
+	})
+	return resolveEnvs(list, vars, ask)
 }
 
-func promptOrGenerateEnvs(list map[string]env) ([]string, error) {
-	var toGenerate []string
-	var toPrompt = make(map[string]env)
+// generateEnvs computes the values of the env vars without prompting, using
+// the answers, or their generators or default values.
+func generateEnvs(list map[string]env, vars, answers map[string]string) ([]string, error) {
+	list, ask := answerEnvs(list, answers, func(_ string, e env) (string, error) {
+		return e.Value, nil
+	})
+	out, _, err := resolveEnvs(list, vars, ask)
+	return out, err
+}
 
+// answerEnvs makes the env vars found in answers, typically read from an env
+// file, take their value from there instead of their generator or ask. It
+// returns the updated list, and the ask function to use with it.
+func answerEnvs(list map[string]env, answers map[string]string, ask func(k string, e env) (string, error)) (map[string]env, func(k string, e env) (string, error)) {
+	out := make(map[string]env)
 	for k, e := range list {
-		if e.Generator == "secret" {
-			toGenerate = append(toGenerate, k)
-		} else {
-			toPrompt[k] = e
+		if _, ok := answers[k]; ok {
+			e.Generator = generatorSpec{}
+		}
+		out[k] = e
+	}
+	return out, func(k string, e env) (string, error) {
+		answer, ok := answers[k]
+		if !ok {
+			return ask(k, e)
+		}
+		if answer == "" && e.Value == "" && e.isRequired() {
+			return "", fmt.Errorf("no value for the required environment variable %s in the env file", k)
 		}
+		if err := e.check(answer); err != nil {
+			return "", fmt.Errorf("invalid value for %s in the env file: %v", k, err)
+		}
+		return answer, nil
 	}
+}
 
-	generated, err := generateEnvs(toGenerate)
+// resolveEnvs computes the values of the env vars so that the values they
+// refer to are computed first, and skips those whose condition isn't met.
+// Generators provide the values of the env vars that use them, and ask
+// provides the others given their expanded default value. vars holds the
+// values that the env vars can refer to besides each other. The env vars that
+// refer to SERVICE_URL while it isn't in vars are returned separately.
+func resolveEnvs(list map[string]env, vars map[string]string, ask func(k string, e env) (string, error)) ([]string, map[string]env, error) {
+	order, err := orderEnvs(list)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	prompted, err := promptEnv(toPrompt)
-	if err != nil {
-		return nil, err
+	values := make(map[string]string)
+	for k, v := range vars {
+		values[k] = v
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := values[k]
+		return v, ok
 	}
 
-	return append(generated, prompted...), nil
+	var out []string
+	deferred := make(map[string]env)
+	for _, k := range order {
+		e := list[k]
+		if refersToDeferred(e, values, deferred) {
+			deferred[k] = e
+			continue
+		}
+		if !e.enabled(values) {
+			// neither prompted nor set, but other values can still refer to it
+			values[k] = ""
+			continue
+		}
+
+		var v string
+		if e.Generator.Type != "" {
+			v, err = e.Generator.generate(values)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to generate value for %s: %v", k, err)
+			}
+		} else {
+			e.Value, err = expandTemplate(e.Value, lookup)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to compute the default value of %s: %v", k, err)
+			}
+			answer, err := ask(k, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			kv, ok := envAnswer(k, e, answer)
+			if !ok {
+				// left unset, but other values can still refer to it
+				values[k] = ""
+				continue
+			}
+			v = strings.SplitN(kv, "=", 2)[1]
+		}
+		values[k] = v
+		out = append(out, k+"="+v)
+	}
+	return out, deferred, nil
 }
 
-func generateEnvs(keys []string) ([]string, error) {
-	for i, key := range keys {
-		resp, err := rand64String()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate secret for %s : %v", key, err)
+// refersToDeferred checks if the env var refers to SERVICE_URL while it has no
+// value, or to an env var that does.
+func refersToDeferred(e env, values map[string]string, deferred map[string]env) bool {
+	for _, ref := range envDeps(e) {
+		if _, ok := deferred[ref]; ok {
+			return true
+		}
+		if _, ok := values[ref]; ref == serviceURLVar && !ok {
+			return true
 		}
-		keys[i] = key + "=" + resp
 	}
+	return false
+}
 
-	return keys, nil
+// splitSecretEnvs separates the K=V pairs of the variables that the env var
+// definitions mark as secret from the others.
+func splitSecretEnvs(envs []string, list map[string]env) ([]string, map[string]string) {
+	var plain []string
+	secret := make(map[string]string)
+	for _, kv := range envs {
+		p := strings.SplitN(kv, "=", 2)
+		if e, ok := list[p[0]]; ok && e.Secret && len(p) == 2 {
+			secret[p[0]] = p[1]
+			continue
+		}
+		plain = append(plain, kv)
+	}
+	return plain, secret
 }
 
 type envKeyValuePair struct {
@@ -244,27 +1019,94 @@ func sortedEnvs(envs map[string]env) []string {
 	return keys
 }
 
-func promptEnv(list map[string]env) ([]string, error) {
-	var out []string
-	sortedKeys := sortedEnvs(list)
+// unsetOption is the choice of optional enum env vars that leaves them unset.
+const unsetOption = "(unset)"
 
-	for _, k := range sortedKeys {
-		e := list[k]
-		var resp string
+// promptEnv prompts for the value of the env var.
+func promptEnv(k string, e env) (string, error) {
+	var resp string
+
+	message := fmt.Sprintf("Value of %s environment variable (%s)",
+		color.CyanString(k),
+		color.HiBlackString(e.Description))
+	var validators []survey.AskOpt
+	if e.isRequired() {
+		validators = append(validators, survey.WithValidator(survey.Required))
+	} else {
+		message += " (optional)"
+	}
+	var prompt survey.Prompt
+	switch {
+	case len(e.Enum) > 0:
+		options := e.Enum
+		if !e.isRequired() {
+			options = append([]string{unsetOption}, options...)
+		}
+		sel := &survey.Select{Message: message, Options: options}
+		for _, o := range options {
+			// an existing value may not be one of the options anymore
+			if e.Value != "" && o == e.Value {
+				sel.Default = e.Value
+			}
+		}
+		prompt = sel
+	case e.Sensitive:
+		if e.Value != "" {
+			// the default can't be shown, so an empty answer keeps it
+			message += " (leave empty to use the default)"
+			validators = nil
+		}
+		prompt = &survey.Password{Message: message}
+	default:
+		prompt = &survey.Input{Message: message, Default: e.Value}
+	}
+	if len(e.Enum) == 0 {
+		validators = append(validators, survey.WithValidator(func(ans interface{}) error {
+			return e.check(ans.(string))
+		}))
+	}
+	if err := survey.AskOne(prompt, &resp, append(validators, surveyIconOpts)...); err != nil {
+		return "", fmt.Errorf("failed to get a response for environment variable %s", k)
+	}
+	return resp, nil
+}
+
+// generatesSecret checks if the env var has a generated value that should be
+// kept secret.
+func (e env) generatesSecret() bool {
+	if e.Generator.Type == "" {
+		return false
+	}
+	return e.Secret || e.Sensitive || e.Generator.Type == "secret" || e.Generator.Type == "password"
+}
 
-		if err := survey.AskOne(&survey.Input{
-			Message: fmt.Sprintf("Value of %s environment variable (%s)",
-				color.CyanString(k),
-				color.HiBlackString(e.Description)),
-			Default: e.Value,
-		}, &resp,
-			survey.WithValidator(survey.Required),
-			surveyIconOpts,
-		); err != nil {
-			return nil, fmt.Errorf("failed to get a response for environment variable %s", k)
+// isRequired checks if the env var must have a value.
+func (e env) isRequired() bool {
+	return e.Required == nil || *e.Required
+}
+
+// optionalEnvs returns the keys of the env vars that aren't required.
+func optionalEnvs(list map[string]env) []string {
+	var out []string
+	for _, k := range sortedEnvs(list) {
+		if !list[k].isRequired() {
+			out = append(out, k)
 		}
-		out = append(out, k+"="+resp)
 	}
+	return out
+}
 
-	return out, nil
+// envAnswer returns the K=V pair for the answer to the prompt of the env var.
+// Empty answers fall back to the default value, and optional env vars without
+// a value are left out.
+func envAnswer(k string, e env, answer string) (string, bool) {
+	if answer == unsetOption && len(e.Enum) > 0 {
+		answer = ""
+	} else if answer == "" {
+		answer = e.Value
+	}
+	if answer == "" && !e.isRequired() {
+		return "", false
+	}
+	return k + "=" + answer, true
 }
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -27,6 +28,9 @@ var (
 	// used as convenience to take their reference in tests
 	tru  = true
 	fals = false
+	zero = 0
+	one  = 1
+	two  = 2
 )
 
 func TestHasAppFile(t *testing.T) {
@@ -115,7 +119,77 @@ func Test_parseAppFile(t *testing.T) {
 			"env": {"KEY":{"value": 100}}}`, nil, true},
 		{"generator secret", `{
 			"env": {"KEY":{"generator": "secret"}}}`, &appFile{Env: map[string]env{
-			"KEY": {Required: &tru, Generator: "secret"}}}, false},
+			"KEY": {Required: &tru, Generator: generatorSpec{Type: "secret"}}}}, false},
+		{"generator with parameters", `{
+			"env": {"KEY":{"generator": {"type": "alnum", "length": 16}}}}`, &appFile{Env: map[string]env{
+			"KEY": {Required: &tru, Generator: generatorSpec{Type: "alnum", Length: 16}}}}, false},
+		{"unknown generator", `{
+			"env": {"KEY":{"generator": "foo"}}}`, nil, true},
+		{"unknown generator parameter", `{
+			"env": {"KEY":{"generator": {"type": "alnum", "size": 16}}}}`, nil, true},
+		{"unsupported generator parameter", `{
+			"env": {"KEY":{"generator": {"type": "uuid", "length": 16}}}}`, nil, true},
+		{"generator with value", `{
+			"env": {"KEY":{"generator": "uuid", "value": "asdf"}}}`, nil, true},
+		{"template refers to unknown var", `{
+			"env": {"KEY":{"generator": {"type": "template", "template": "${FOO}"}}}}`, nil, true},
+		{"template cycle", `{
+			"env": {
+				"A":{"generator": {"type": "template", "template": "${B}"}},
+				"B":{"generator": {"type": "template", "template": "x${A}"}}
+			}}`, nil, true},
+		{"typed env", `{
+			"env": {"KEY":{"type": "int", "min": 1, "max": 10, "value": "5"}}}`, &appFile{Env: map[string]env{
+			"KEY": {Required: &tru, Type: "int", Min: mkFloat(1), Max: mkFloat(10), Value: "5"}}}, false},
+		{"unknown env type", `{
+			"env": {"KEY":{"type": "float"}}}`, nil, true},
+		{"invalid env pattern", `{
+			"env": {"KEY":{"pattern": "("}}}`, nil, true},
+		{"default value doesn't match type", `{
+			"env": {"KEY":{"type": "int", "value": "abc"}}}`, nil, true},
+		{"default value not in enum", `{
+			"env": {"KEY":{"enum": ["a", "b"], "value": "c"}}}`, nil, true},
+		{"enum value doesn't match pattern", `{
+			"env": {"KEY":{"enum": ["a", "1"], "pattern": "[a-z]+"}}}`, nil, true},
+		{"min greater than max", `{
+			"env": {"KEY":{"min": 10, "max": 1}}}`, nil, true},
+		{"min on bool", `{
+			"env": {"KEY":{"type": "bool", "min": 1}}}`, nil, true},
+		{"value refers to context", `{
+			"env": {"KEY":{"value": "${SERVICE_URL}/callback"}}}`, &appFile{Env: map[string]env{
+			"KEY": {Required: &tru, Value: "${SERVICE_URL}/callback"}}}, false},
+		{"value refers to unknown var", `{
+			"env": {"KEY":{"value": "${FOO}"}}}`, nil, true},
+		{"value with literal reference", `{
+			"env": {"KEY":{"value": "$${HOME}/data"}}}`, &appFile{Env: map[string]env{
+			"KEY": {Required: &tru, Value: "$${HOME}/data"}}}, false},
+		{"values refer to each other", `{
+			"env": {"A":{"value": "${B}"}, "B":{"value": "${A}"}}}`, nil, true},
+		{"service env refers to dependency URL", `{
+			"services": [
+				{"name": "api"},
+				{"name": "web", "depends-on": ["api"], "env": {"KEY": {"value": "${API_URL}/v1"}}}
+			]}`, &appFile{Services: []service{
+			{Name: "api"},
+			{Name: "web", DependsOn: []string{"api"}, Env: map[string]env{"KEY": {Required: &tru, Value: "${API_URL}/v1"}}},
+		}}, false},
+		{"when", `{
+			"env": {
+				"ENABLE_EMAIL": {"type": "bool"},
+				"SMTP_HOST": {"when": "ENABLE_EMAIL"}
+			}}`, &appFile{Env: map[string]env{
+			"ENABLE_EMAIL": {Required: &tru, Type: "bool"},
+			"SMTP_HOST":    {Required: &tru, When: "ENABLE_EMAIL"},
+		}}, false},
+		{"invalid when", `{
+			"env": {"A": {}, "B": {"when": "A =="}}}`, nil, true},
+		{"when refers to unknown var", `{
+			"env": {"B": {"when": "A == true"}}}`, nil, true},
+		{"when cycle", `{
+			"env": {"A": {"when": "B"}, "B": {"when": "!A"}}}`, nil, true},
+		{"secret", `{
+			"env": {"KEY":{"secret": true}}}`, &appFile{Env: map[string]env{
+			"KEY": {Required: &tru, Secret: true}}}, false},
 		{"generator secret and value", `{
 			"env": {"KEY":{"generator": "secret", "value": "asdf"}}}`, nil, true},
 		{"parses ok", `{
@@ -159,6 +233,121 @@ func Test_parseAppFile(t *testing.T) {
 					]
 				}
 			}}`, &appFile{Hooks: hooks{PostCreate: hook{Commands: []string{"echo post"}}}}, false},
+		{"services", `{
+			"services": [
+				{"name": "api", "dir": "api", "env": {"KEY": {}}},
+				{"name": "web", "dir": "web", "depends-on": ["api"]}
+			]}`, &appFile{Services: []service{
+			{Name: "api", Dir: "api", Env: map[string]env{"KEY": {Required: &tru}}},
+			{Name: "web", Dir: "web", DependsOn: []string{"api"}},
+		}}, false},
+		{"services with top-level env", `{
+			"env": {"KEY": {}},
+			"services": [{"name": "api"}]}`, nil, true},
+		{"services with top-level options", `{
+			"options": {"memory": "1Gi"},
+			"services": [{"name": "api"}]}`, nil, true},
+		{"service without name", `{
+			"services": [{"dir": "api"}]}`, nil, true},
+		{"duplicate service names", `{
+			"services": [{"name": "api"}, {"name": "api"}]}`, nil, true},
+		{"service depends on unknown service", `{
+			"services": [{"name": "api", "depends-on": ["db"]}]}`, nil, true},
+		{"service dependency cycle", `{
+			"services": [
+				{"name": "a", "depends-on": ["b"]},
+				{"name": "b", "depends-on": ["a"]}
+			]}`, nil, true},
+		{"service env set to the URL of a dependency", `{
+			"services": [{"name": "api"}, {"name": "web", "depends-on": ["api"], "env": {"API_URL": {"value": "x"}}}]}`, nil, true},
+		{"services deployed with the same name", `{"services": [{"name": "api"}, {"name": "API"}]}`, nil, true},
+		{"service env is validated", `{
+			"services": [{"name": "api", "env": {"KEY": {"generator": "secret", "value": "asdf"}}}]}`, nil, true},
+		{"scaling and timeout options", `{"options": {
+			"min-instances": 1, "max-instances": 3, "timeout": 900, "cpu-boost": true, "cpu-throttling": false}}`,
+			&appFile{Options: options{MinInstances: &one, MaxInstances: 3, Timeout: 900, CPUBoost: &tru, CPUThrottling: &fals}}, false},
+		{"zero min-instances", `{"options": {"min-instances": 0}}`, &appFile{Options: options{MinInstances: &zero}}, false},
+		{"min-instances greater than max-instances", `{"options": {"min-instances": 4, "max-instances": 3}}`, nil, true},
+		{"negative min-instances", `{"options": {"min-instances": -1}}`, nil, true},
+		{"timeout too long", `{"options": {"timeout": 3601}}`, nil, true},
+		{"networking options", `{"options": {
+			"ingress": "internal", "network": "default", "subnet": "sub", "network-tags": ["a"], "vpc-egress": "all-traffic"}}`,
+			&appFile{Options: options{Ingress: "internal", Network: "default", Subnet: "sub", NetworkTags: []string{"a"}, VPCEgress: "all-traffic"}}, false},
+		{"unknown ingress", `{"options": {"ingress": "internet"}}`, nil, true},
+		{"unknown vpc-egress", `{"options": {"vpc-connector": "c", "vpc-egress": "everything"}}`, nil, true},
+		{"vpc-egress without vpc access", `{"options": {"vpc-egress": "all-traffic"}}`, nil, true},
+		{"vpc-connector with network", `{"options": {"vpc-connector": "c", "network": "default"}}`, nil, true},
+		{"network-tags without network", `{"options": {"network-tags": ["a"]}}`, nil, true},
+		{"service account", `{"service-account": {"roles": ["roles/datastore.user"]}}`,
+			&appFile{ServiceAccount: &serviceAccount{Roles: []string{"roles/datastore.user"}}}, false},
+		{"invalid service account role", `{"service-account": {"roles": ["datastore.user"]}}`, nil, true},
+		{"services with top-level service account", `{
+			"service-account": {"name": "my-app-sa"},
+			"services": [{"name": "api"}]}`, nil, true},
+		{"cloudsql", `{"cloudsql": {"database-version": "MYSQL_8_0", "database": "app"}}`,
+			&appFile{CloudSQL: &cloudSQL{DatabaseVersion: "MYSQL_8_0", Database: "app"}}, false},
+		{"unsupported cloudsql database version", `{"cloudsql": {"database-version": "SQLSERVER_2019_STANDARD"}}`, nil, true},
+		{"env conflicting with cloudsql", `{
+			"cloudsql": {},
+			"env": {"DB_USER": {"value": "me"}}}`, nil, true},
+		{"env referring to cloudsql vars", `{
+			"cloudsql": {},
+			"env": {"DATABASE_URL": {"generator": {"type": "template", "template": "postgres://${DB_USER}:${DB_PASS}@/${DB_NAME}"}}}}`,
+			&appFile{CloudSQL: &cloudSQL{}, Env: map[string]env{"DATABASE_URL": {Required: &tru,
+				Generator: generatorSpec{Type: "template", Template: "postgres://${DB_USER}:${DB_PASS}@/${DB_NAME}"}}}}, false},
+		{"cloudsql vars without cloudsql", `{
+			"env": {"DATABASE_URL": {"generator": {"type": "template", "template": "${DB_PASS}"}}}}`, nil, true},
+		{"services with top-level cloudsql", `{
+			"cloudsql": {},
+			"services": [{"name": "api"}]}`, nil, true},
+		{"volumes", `{"volumes": [{"name": "cache", "mount-path": "/cache", "in-memory": {"size-limit": "64Mi"}}]}`,
+			&appFile{Volumes: []volume{{Name: "cache", MountPath: "/cache", InMemory: &inMemoryVolume{SizeLimit: "64Mi"}}}}, false},
+		{"invalid volume", `{"volumes": [{"name": "cache", "mount-path": "/cache"}]}`, nil, true},
+		{"services with top-level volumes", `{
+			"volumes": [{"name": "cache", "mount-path": "/cache", "in-memory": {}}],
+			"services": [{"name": "api"}]}`, nil, true},
+		{"sidecars", `{"sidecars": [{"name": "proxy", "image": "nginx", "port": 8080, "depends-on": ["app"]}]}`,
+			&appFile{Sidecars: []sidecar{{Name: "proxy", Image: "nginx", Port: 8080, DependsOn: []string{"app"}}}}, false},
+		{"invalid sidecar", `{"sidecars": [{"name": "proxy"}]}`, nil, true},
+		{"probes", `{"probes": {"startup": {"path": "/healthz", "period": 10, "failure-threshold": 30}}}`,
+			&appFile{Probes: &probes{Startup: &probe{Path: "/healthz", Period: 10, FailureThreshold: 30}}}, false},
+		{"invalid probe", `{"probes": {"liveness": {"type": "tcp"}}}`, nil, true},
+		{"command options", `{"options": {"command": ["flask"], "args": ["run"], "working-dir": "/app"}}`,
+			&appFile{Options: options{Command: []string{"flask"}, Args: []string{"run"}, WorkingDir: "/app"}}, false},
+		{"relative working-dir", `{"options": {"working-dir": "app"}}`, nil, true},
+		{"build strategy", `{"build": {"strategy": "jib-maven"}}`,
+			&appFile{Build: build{Strategy: "jib-maven"}}, false},
+		{"unknown build strategy", `{"build": {"strategy": "make"}}`, nil, true},
+		{"docker build", `{"build": {"docker": {"dockerfile": "deploy/Dockerfile", "target": "prod", "args": {"PROJECT": "${GOOGLE_CLOUD_PROJECT}"}}}}`,
+			&appFile{Build: build{Docker: dockerConfig{Dockerfile: "deploy/Dockerfile", Target: "prod", Args: map[string]string{"PROJECT": "${GOOGLE_CLOUD_PROJECT}"}}}}, false},
+		{"build arg with unknown variable", `{"build": {"docker": {"args": {"A": "${NOPE}"}}}}`, nil, true},
+		{"build arg with service URL", `{"build": {"docker": {"args": {"URL": "${SERVICE_URL}"}}}}`, nil, true},
+		{"build arg with secret", `{"env": {"TOKEN": {"secret": true}}, "build": {"docker": {"args": {"TOKEN": "${TOKEN}"}}}}`, nil, true},
+		{"build arg with sensitive", `{"env": {"KEY": {"sensitive": true}}, "build": {"docker": {"args": {"KEY": "${KEY}"}}}}`, nil, true},
+		{"build arg with generated password", `{"env": {"PASS": {"generator": {"type": "password"}}}, "build": {"docker": {"args": {"PASS": "${PASS}"}}}}`, nil, true},
+		{"build arg with value referring to a secret", `{"env": {"TOKEN": {"secret": true}, "URL": {"value": "https://x?t=${TOKEN}"}}, "build": {"docker": {"args": {"URL": "${URL}"}}}}`, nil, true},
+		{"build arg with database password", `{"cloudsql": {}, "build": {"docker": {"args": {"PASS": "${DB_PASS}"}}}}`, nil, true},
+		{"remote build", `{"build": {"remote": true}}`,
+			&appFile{Build: build{Remote: &tru}}, false},
+		{"job", `{"kind": "job", "job": {"tasks": 5, "parallelism": 5, "execute": true}}`,
+			&appFile{Kind: "job", Job: &job{Tasks: 5, Parallelism: 5, Execute: true}}, false},
+		{"job with service options", `{"kind": "job", "options": {"port": 8080}}`, nil, true},
+		{"service depending on a job", `{"services": [
+			{"name": "migrate", "kind": "job"},
+			{"name": "api", "depends-on": ["migrate"]}
+		]}`, nil, true},
+		{"labels", `{"labels": {"team": "finance"}}`,
+			&appFile{Labels: map[string]string{"team": "finance"}}, false},
+		{"invalid label", `{"labels": {"Team": "finance"}}`, nil, true},
+		{"services with labels", `{
+			"labels": {"team": "finance"},
+			"services": [{"name": "api"}]}`,
+			&appFile{Labels: map[string]string{"team": "finance"}, Services: []service{{Name: "api"}}}, false},
+		{"kms key", `{"kms-key": "projects/p/locations/us-central1/keyRings/r/cryptoKeys/k"}`,
+			&appFile{KMSKey: "projects/p/locations/us-central1/keyRings/r/cryptoKeys/k"}, false},
+		{"invalid kms key", `{"kms-key": "k"}`, nil, true},
+		{"service options are validated", `{
+			"services": [{"name": "api", "options": {"timeout": -1}}]}`, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -252,6 +441,379 @@ func Test_sortedEnvs(t *testing.T) {
 	}
 }
 
+func Test_env_check(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     env
+		value   string
+		wantErr bool
+	}{
+		{"empty is not checked", env{Type: "int"}, "", false},
+		{"string", env{}, "anything", false},
+		{"string min length", env{Min: mkFloat(3)}, "ab", true},
+		{"string max length", env{Max: mkFloat(3)}, "abcd", true},
+		{"int", env{Type: "int"}, "42", false},
+		{"not an int", env{Type: "int"}, "4.2", true},
+		{"int below min", env{Type: "int", Min: mkFloat(1)}, "0", true},
+		{"int above max", env{Type: "int", Max: mkFloat(10)}, "11", true},
+		{"int within bounds", env{Type: "int", Min: mkFloat(1), Max: mkFloat(10)}, "10", false},
+		{"bool", env{Type: "bool"}, "true", false},
+		{"not a bool", env{Type: "bool"}, "yes", true},
+		{"url", env{Type: "url"}, "https://example.com/path", false},
+		{"relative url", env{Type: "url"}, "/path", true},
+		{"email", env{Type: "email"}, "me@example.com", false},
+		{"email with name", env{Type: "email"}, "Me <me@example.com>", true},
+		{"not an email", env{Type: "email"}, "me", true},
+		{"pattern", env{Pattern: "[a-z]+"}, "abc", false},
+		{"pattern must match entirely", env{Pattern: "[a-z]+"}, "abc1", true},
+		{"enum", env{Enum: []string{"a", "b"}}, "b", false},
+		{"not in enum", env{Enum: []string{"a", "b"}}, "c", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.env.check(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("check(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_optionalEnvs(t *testing.T) {
+	list := map[string]env{
+		"REQUIRED":            {Required: &tru},
+		"REQUIRED_BY_DEFAULT": {},
+		"OPTIONAL":            {Required: &fals},
+		"OPTIONAL_FIRST":      {Required: &fals, Order: mkInt(-1)},
+	}
+	if got, expected := optionalEnvs(list), []string{"OPTIONAL_FIRST", "OPTIONAL"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("optionalEnvs() = %v, want %v", got, expected)
+	}
+}
+
+func Test_resolveEnvs(t *testing.T) {
+	list := map[string]env{
+		"CALLBACK":  {Required: &tru, Value: "${SERVICE_URL}/oauth/callback"},
+		"BUCKET":    {Required: &tru, Value: "${GOOGLE_CLOUD_PROJECT}-uploads", Order: mkInt(2)},
+		"PREFIX":    {Required: &tru, Order: mkInt(1)},
+		"FULL_PATH": {Required: &tru, Value: "${BUCKET}/${PREFIX}/${OPTIONAL}", Order: mkInt(0)},
+		"OPTIONAL":  {Required: &fals},
+		"LATER":     {Generator: generatorSpec{Type: "template", Template: "${CALLBACK}?x"}},
+		"ID":        {Generator: generatorSpec{Type: "uuid"}},
+	}
+	var asked []string
+	got, deferred, err := resolveEnvs(list, map[string]string{"GOOGLE_CLOUD_PROJECT": "proj"},
+		func(k string, e env) (string, error) {
+			asked = append(asked, k+"="+e.Value)
+			if k == "PREFIX" {
+				return "p", nil
+			}
+			return "", nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// FULL_PATH is ordered first but asked after the variables it refers to
+	if expected := []string{"BUCKET=proj-uploads", "PREFIX=", "OPTIONAL=", "FULL_PATH=proj-uploads/p/"}; !reflect.DeepEqual(asked[:4], expected) {
+		t.Fatalf("asked for %v, want %v", asked, expected)
+	}
+	values := parseEnv(got)
+	if _, ok := values["OPTIONAL"]; ok {
+		t.Fatal("optional env var left empty was set")
+	}
+	if values["FULL_PATH"] != "proj-uploads/p/" || values["ID"] == "" {
+		t.Fatalf("wrong values: %v", values)
+	}
+	if _, ok := deferred["CALLBACK"]; !ok || len(deferred) != 2 {
+		t.Fatalf("expected CALLBACK and LATER to be deferred, got %v", deferred)
+	}
+
+	later, err := generateEnvs(deferred, map[string]string{"SERVICE_URL": "https://svc"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"CALLBACK=https://svc/oauth/callback", "LATER=https://svc/oauth/callback?x"}; !reflect.DeepEqual(later, expected) {
+		t.Fatalf("generateEnvs() = %v, want %v", later, expected)
+	}
+}
+
+func Test_resolveEnvs_literal(t *testing.T) {
+	list := map[string]env{"DATA": {Required: &tru, Value: "$${HOME}/data"}}
+	got, _, err := resolveEnvs(list, nil, func(k string, e env) (string, error) { return e.Value, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"DATA=${HOME}/data"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("resolveEnvs() = %v, want %v", got, expected)
+	}
+}
+
+func Test_resolveEnvs_when(t *testing.T) {
+	// SMTP_HOST comes first in the order of sortedEnvs, but after
+	// ENABLE_EMAIL its condition refers to
+	list := map[string]env{
+		"SMTP_HOST":    {Required: &tru, When: "ENABLE_EMAIL", Order: mkInt(0)},
+		"ENABLE_EMAIL": {Required: &tru, Order: mkInt(1)},
+		"SMTP_PORT":    {Required: &tru, Value: "25", When: "ENABLE_EMAIL && SMTP_HOST != localhost"},
+		"DEBUG_TOKEN":  {When: "!ENABLE_EMAIL", Generator: generatorSpec{Type: "uuid"}},
+	}
+	tests := []struct {
+		name        string
+		answers     map[string]string
+		wantAsked   []string
+		wantKeys    []string
+		wantSkipped []string
+	}{
+		{"disabled",
+			map[string]string{"ENABLE_EMAIL": "false"},
+			[]string{"ENABLE_EMAIL"},
+			[]string{"DEBUG_TOKEN", "ENABLE_EMAIL"},
+			[]string{"SMTP_HOST", "SMTP_PORT"}},
+		{"enabled",
+			map[string]string{"ENABLE_EMAIL": "true", "SMTP_HOST": "smtp.example.com"},
+			[]string{"ENABLE_EMAIL", "SMTP_HOST", "SMTP_PORT"},
+			[]string{"ENABLE_EMAIL", "SMTP_HOST", "SMTP_PORT"},
+			[]string{"DEBUG_TOKEN"}},
+		{"partially enabled",
+			map[string]string{"ENABLE_EMAIL": "1", "SMTP_HOST": "localhost"},
+			[]string{"ENABLE_EMAIL", "SMTP_HOST"},
+			[]string{"ENABLE_EMAIL", "SMTP_HOST"},
+			[]string{"DEBUG_TOKEN", "SMTP_PORT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var asked []string
+			got, _, err := resolveEnvs(list, nil, func(k string, e env) (string, error) {
+				asked = append(asked, k)
+				return tt.answers[k], nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(asked, tt.wantAsked) {
+				t.Errorf("asked for %v, want %v", asked, tt.wantAsked)
+			}
+			var keys []string
+			for k := range parseEnv(got) {
+				keys = append(keys, k)
+			}
+			if !reflect.DeepEqual(sortStrings(keys), tt.wantKeys) {
+				t.Errorf("set %v, want %v", sortStrings(keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func Test_answerEnvs(t *testing.T) {
+	list := map[string]env{
+		"ANSWERED":  {Value: "default"},
+		"GENERATED": {Generator: generatorSpec{Type: "uuid"}},
+		"EMPTY":     {Value: "default"},
+		"OPTIONAL":  {Required: &fals},
+		"PROMPTED":  {},
+		"DEPENDENT": {Value: "${ANSWERED}-x"},
+	}
+	answers := map[string]string{"ANSWERED": "a", "GENERATED": "g", "EMPTY": "", "OPTIONAL": "", "EXTRA": "e"}
+	var asked []string
+	list, ask := answerEnvs(list, answers, func(k string, e env) (string, error) {
+		asked = append(asked, k)
+		return "prompted", nil
+	})
+	got, _, err := resolveEnvs(list, nil, ask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if expected := []string{"ANSWERED=a", "DEPENDENT=prompted", "EMPTY=default", "GENERATED=g", "PROMPTED=prompted"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("resolveEnvs() = %v, want %v", got, expected)
+	}
+	sort.Strings(asked)
+	if expected := []string{"DEPENDENT", "PROMPTED"}; !reflect.DeepEqual(asked, expected) {
+		t.Fatalf("asked for %v, want %v", asked, expected)
+	}
+
+	for name, answers := range map[string]map[string]string{
+		"missing required": {"REQUIRED": ""},
+		"invalid":          {"REQUIRED": "x", "PORT": "http"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			list, ask := answerEnvs(map[string]env{
+				"REQUIRED": {Required: &tru},
+				"PORT":     {Type: "int", Value: "8080"},
+			}, answers, func(string, env) (string, error) { return "", nil })
+			if _, _, err := resolveEnvs(list, nil, ask); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func Test_envAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    env
+		answer string
+		want   string
+		wantOk bool
+	}{
+		{"required answer", env{Required: &tru}, "v", "K=v", true},
+		{"required empty uses default", env{Required: &tru, Value: "d"}, "", "K=d", true},
+		{"optional answer", env{Required: &fals}, "v", "K=v", true},
+		{"optional empty is omitted", env{Required: &fals}, "", "", false},
+		{"optional empty uses default", env{Required: &fals, Value: "d"}, "", "K=d", true},
+		{"optional enum unset", env{Required: &fals, Enum: []string{"a"}, Value: "a"}, unsetOption, "", false},
+		{"unset option is a plain value without enum", env{Required: &fals}, unsetOption, "K=" + unsetOption, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := envAnswer("K", tt.env, tt.answer)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("envAnswer() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_needEnvs(t *testing.T) {
+	list := map[string]env{
+		"NEW":       {Value: "default"},
+		"KEPT":      {Value: "default"},
+		"UPDATED":   {Value: "default"},
+		"GENERATED": {Generator: generatorSpec{Type: "secret"}},
+		"SECRET":    {Value: "default", Secret: true},
+	}
+	existing := map[string]string{
+		"KEPT":      "kept",
+		"UPDATED":   "old",
+		"GENERATED": "generated",
+		"SECRET":    "svc-secret:latest",
+		"MANUAL":    "manual",
+	}
+
+	if got, expected := existingEnvs(list, existing), []string{"GENERATED", "KEPT", "SECRET", "UPDATED"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("existingEnvs() = %v, want %v", got, expected)
+	}
+
+	got := needEnvs(list, existing, []string{"UPDATED", "GENERATED", "SECRET", "MANUAL"})
+	expected := map[string]env{
+		"NEW":       {Value: "default"},
+		"UPDATED":   {Value: "old"},
+		"GENERATED": {Value: "generated"},
+		"SECRET":    {Value: "default", Secret: true},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("needEnvs() = %+v, want %+v", got, expected)
+	}
+	if _, ok := list["KEPT"]; !ok {
+		t.Fatal("needEnvs() modified the list of envs")
+	}
+}
+
+func Test_appFile_services(t *testing.T) {
+	single := appFile{Env: map[string]env{"KEY": {Value: "v"}}, Options: options{Port: 80}}
+	got, err := single.services("repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []service{{Name: "repo", Env: single.Env, Options: single.Options}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("wrong single service: expected:%#v\ngot=%#v", expected, got)
+	}
+
+	single.Name = "foo"
+	got, err = single.services("repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "foo" {
+		t.Fatalf("app.json name not used as the service name: %#v", got)
+	}
+}
+
+func Test_sortServices(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []service
+		want    []string
+		wantErr bool
+	}{
+		{"no dependencies keeps order",
+			[]service{{Name: "b"}, {Name: "a"}, {Name: "c"}},
+			[]string{"b", "a", "c"}, false},
+		{"dependencies first",
+			[]service{
+				{Name: "web", DependsOn: []string{"api"}},
+				{Name: "api", DependsOn: []string{"worker"}},
+				{Name: "worker"},
+			},
+			[]string{"worker", "api", "web"}, false},
+		{"shared dependency",
+			[]service{
+				{Name: "web", DependsOn: []string{"api", "worker"}},
+				{Name: "worker", DependsOn: []string{"api"}},
+				{Name: "api"},
+			},
+			[]string{"api", "worker", "web"}, false},
+		{"self dependency",
+			[]service{{Name: "a", DependsOn: []string{"a"}}},
+			nil, true},
+		{"cycle",
+			[]service{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"c"}},
+				{Name: "c", DependsOn: []string{"a"}},
+			},
+			nil, true},
+		{"unknown dependency",
+			[]service{{Name: "a", DependsOn: []string{"b"}}},
+			nil, true},
+		{"same deployed name",
+			[]service{{Name: "my-api"}, {Name: "My_API"}},
+			nil, true},
+		{"same deployed name once truncated",
+			[]service{{Name: strings.Repeat("a", 63) + "-x"}, {Name: strings.Repeat("a", 63) + "-y"}},
+			nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sortServices(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sortServices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, s := range got {
+				names = append(names, s.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("sortServices() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func Test_serviceURLEnv(t *testing.T) {
+	for name, want := range map[string]string{
+		"my-api":  "MY_API_URL",
+		"my.api":  "MY_API_URL",
+		"1api":    "SVC_1API_URL",
+		"-worker": "SVC_WORKER_URL",
+	} {
+		if got := serviceURLEnv(name); got != want {
+			t.Errorf("serviceURLEnv(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
 func mkInt(i int) *int {
 	return &i
 }
+
+func mkFloat(f float64) *float64 {
+	return &f
+}
+
+func sortStrings(s []string) []string {
+	out := append([]string(nil), s...)
+	sort.Strings(out)
+	return out
+}
@@ -26,7 +26,8 @@ import (
 )
 
 // Create a "Cloud Run Source Deploy" repository in Artifact Registry (if it doesn't already exist)
-func createArtifactRegistry(project string, region string, repoName string) error {
+// with the labels and the encryption key. The encryption key of an existing repository can't change.
+func createArtifactRegistry(project string, region string, repoName string, labels map[string]string, kmsKey string) error {
 
 	repoPrefix := fmt.Sprintf("projects/%s/locations/%s", project, region)
 	repoFull := fmt.Sprintf("%s/repositories/%s", repoPrefix, repoName)
@@ -52,14 +53,20 @@ func createArtifactRegistry(project string, region string, repoName string) erro
 		}
 	}
 
+	if existingRepo != nil && kmsKey != "" && existingRepo.GetKmsKeyName() != kmsKey {
+		return fmt.Errorf("existing repository %s is not encrypted with key %s, and its key can't be changed", repoFull, kmsKey)
+	}
+
 	// If the existing repo doesn't exist, create it
 	if existingRepo == nil {
 		req := &artifactregistrypb.CreateRepositoryRequest{
 			Parent:       repoPrefix,
 			RepositoryId: repoName,
 			Repository: &artifactregistrypb.Repository{
-				Name:   repoFull,
-				Format: artifactregistrypb.Repository_DOCKER,
+				Name:       repoFull,
+				Format:     artifactregistrypb.Repository_DOCKER,
+				Labels:     labels,
+				KmsKeyName: kmsKey,
 			},
 		}
 
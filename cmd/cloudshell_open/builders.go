@@ -0,0 +1,197 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Builder builds the container image of an application from its source.
+type Builder interface {
+	// Detect checks if the builder applies to the source in the directory.
+	Detect(dir string) (bool, error)
+	// Check returns why the builder can't build the source in the directory,
+	// such as a missing file or tool, when it is chosen explicitly.
+	Check(dir string) error
+	// Describe returns what the builder does, e.g. "build this application
+	// with its Dockerfile", and the equivalent command.
+	Describe(req buildRequest) (string, string)
+	// Build builds the image, and returns its digest if known.
+	Build(req buildRequest) (string, error)
+	// NeedsPush checks if the built image must be pushed with docker.
+	NeedsPush() bool
+}
+
+// buildRequest describes the image to build.
+type buildRequest struct {
+	project string
+	region  string
+	// dir holds the source.
+	dir   string
+	image string
+	// builderImage is the buildpacks builder.
+	builderImage string
+	// labels are set on the resources created for the build.
+	labels map[string]string
+	// out receives the build logs of builders that show them.
+	out io.Writer
+	// plain is the request before display formatted it.
+	plain *buildRequest
+}
+
+// display returns the request with its values formatted by f, to describe
+// the command of the builder.
+func (r buildRequest) display(f func(string) string) buildRequest {
+	plain := r
+	r.plain = &plain
+	r.dir = f(r.dir)
+	r.image = f(r.image)
+	r.region = f(r.region)
+	r.builderImage = f(r.builderImage)
+	return r
+}
+
+// unformatted returns the request without the formatting of display.
+func (r buildRequest) unformatted() buildRequest {
+	if r.plain != nil {
+		return *r.plain
+	}
+	return r
+}
+
+const defaultBuilderImage = "gcr.io/buildpacks/builder:v1"
+
+// builders are the built-in builders, by order of priority. Buildpacks apply
+// to any source, so come last.
+var builders = []Builder{
+	dockerBuilder{},
+	composeBuilder{},
+	jibMavenBuilder{},
+	packBuilder{},
+}
+
+// Build strategies choose the builder in app.json.
+const (
+	strategyDocker     = "docker"
+	strategyCompose    = "compose"
+	strategyJibMaven   = "jib-maven"
+	strategyJibGradle  = "jib-gradle"
+	strategyBuildpacks = "buildpacks"
+	strategyKo         = "ko"
+	strategyRemote     = "remote"
+)
+
+// strategies maps the build strategies to their builder.
+var strategies = map[string]Builder{
+	strategyDocker:     dockerBuilder{},
+	strategyCompose:    composeBuilder{},
+	strategyJibMaven:   jibMavenBuilder{},
+	strategyJibGradle:  jibGradleBuilder{},
+	strategyBuildpacks: packBuilder{},
+	strategyKo:         koBuilder{},
+	strategyRemote:     remoteBuilder{},
+}
+
+// validateStrategy checks that the build strategy is known.
+func validateStrategy(strategy string) error {
+	if _, ok := strategies[strategy]; strategy == "" || ok {
+		return nil
+	}
+	var names []string
+	for k := range strategies {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown build strategy %q, must be one of: %s", strategy, strings.Join(names, ", "))
+}
+
+// strategyBuilder returns the builder of the build strategy in the settings,
+// after checking that it can build the source in dir.
+func strategyBuilder(dir string, settings build) (Builder, error) {
+	b, ok := strategies[settings.Strategy]
+	if !ok {
+		return nil, validateStrategy(settings.Strategy)
+	}
+	switch b.(type) {
+	case dockerBuilder:
+		b = dockerBuilder{docker: settings.Docker}
+	case remoteBuilder:
+		dockerfile, err := configuredDockerfileExists(dir, settings.Docker)
+		if err != nil {
+			return nil, err
+		}
+		b = remoteBuilder{dockerfile: dockerfile, docker: settings.Docker}
+	}
+	if err := b.Check(dir); err != nil {
+		return nil, fmt.Errorf("build strategy %q can't be used: %w", settings.Strategy, err)
+	}
+	return b, nil
+}
+
+// detectBuilder returns the first of the builders that applies to the source
+// in dir. Setting a buildpacks builder rules out docker and Jib.
+func detectBuilder(dir string, settings build) (Builder, error) {
+	for _, b := range builders {
+		if settings.Buildpacks.Builder != "" {
+			switch b.(type) {
+			case dockerBuilder, jibMavenBuilder:
+				continue
+			}
+		}
+		if _, ok := b.(dockerBuilder); ok {
+			ok, err := configuredDockerfileExists(dir, settings.Docker)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return dockerBuilder{docker: settings.Docker}, nil
+			}
+			continue
+		}
+		ok, err := b.Detect(dir)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("no builder applies to %s", dir)
+}
+
+// configuredDockerfileExists checks if the source in dir has a Dockerfile. A
+// Dockerfile set in the docker settings must exist.
+func configuredDockerfileExists(dir string, docker dockerConfig) (bool, error) {
+	ok, err := dockerFileExists(docker.dockerfile(dir))
+	if err == nil && !ok && docker.Dockerfile != "" {
+		return false, fmt.Errorf("dockerfile %s doesn't exist", docker.dockerfile(dir))
+	}
+	return ok, err
+}
+
+// remoteBuilderFor returns the builder building on Cloud Build instead of the
+// local builder, if there is one.
+func remoteBuilderFor(b Builder) (Builder, bool) {
+	switch b := b.(type) {
+	case dockerBuilder:
+		return remoteBuilder{dockerfile: true, docker: b.docker}, true
+	case packBuilder:
+		return remoteBuilder{}, true
+	}
+	return nil, false
+}
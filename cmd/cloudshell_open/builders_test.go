@@ -0,0 +1,262 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const jibPom = `<project><build><plugins><plugin>
+<artifactId>jib-maven-plugin</artifactId>
+</plugin></plugins></build></project>`
+
+func Test_detectBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		settings build
+		expected Builder
+		wantErr  bool
+	}{
+		{"empty", nil, build{}, packBuilder{}, false},
+		{"Dockerfile", map[string]string{"Dockerfile": "FROM scratch"}, build{}, dockerBuilder{}, false},
+		{"compose", map[string]string{"compose.yaml": "services: {}"}, build{}, composeBuilder{}, false},
+		{"Dockerfile before compose", map[string]string{"Dockerfile": "FROM scratch", "docker-compose.yml": "services: {}"}, build{}, dockerBuilder{}, false},
+		{"custom buildpacks builder", map[string]string{"Dockerfile": "FROM scratch"},
+			build{Buildpacks: buildpacks{Builder: "builder"}}, packBuilder{}, false},
+		{"compose with custom buildpacks builder", map[string]string{"Dockerfile": "FROM scratch", "compose.yml": "services: {}"},
+			build{Buildpacks: buildpacks{Builder: "builder"}}, composeBuilder{}, false},
+		// the plugin must also be usable by maven
+		{"unusable jib plugin", map[string]string{"pom.xml": jibPom}, build{}, packBuilder{}, false},
+		{"source only", map[string]string{"main.go": "package main", "go.mod": "module app"}, build{}, packBuilder{}, false},
+		{"Dockerfile in subdirectory", map[string]string{"deploy/Dockerfile": "FROM scratch"},
+			build{Docker: dockerConfig{Dockerfile: "deploy/Dockerfile"}}, dockerBuilder{docker: dockerConfig{Dockerfile: "deploy/Dockerfile"}}, false},
+		{"missing Dockerfile in subdirectory", map[string]string{"Dockerfile": "FROM scratch"},
+			build{Docker: dockerConfig{Dockerfile: "deploy/Dockerfile"}}, nil, true},
+	}
+	// maven isn't run, the plugin is unusable
+	defer func(f func(*exec.Cmd) error) { runJibCheck = f }(runJibCheck)
+	runJibCheck = func(*exec.Cmd) error { return errors.New("unusable") }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := detectBuilder(dir, tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectBuilder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("detectBuilder() = %T, want %T", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_jibMavenConfigured(t *testing.T) {
+	defer func(f func(*exec.Cmd) error) { runJibCheck = f }(runJibCheck)
+	var ran []string
+	var checkErr error
+	runJibCheck = func(cmd *exec.Cmd) error {
+		ran = cmd.Args
+		return checkErr
+	}
+
+	dir := t.TempDir()
+	if ok, err := jibMavenConfigured(dir); err != nil || ok || ran != nil {
+		t.Fatalf("jibMavenConfigured() without pom.xml = %v, %v, ran %v", ok, err, ran)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pom.xml"), []byte(jibPom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := jibMavenConfigured(dir); err != nil || !ok {
+		t.Fatalf("jibMavenConfigured() = %v, %v, want true", ok, err)
+	}
+	if !contains(ran, "jib:_skaffold-fail-if-jib-out-of-date") {
+		t.Errorf("jibMavenConfigured() ran %v", ran)
+	}
+	checkErr = errors.New("unusable")
+	if ok, err := jibMavenConfigured(dir); err != nil || ok {
+		t.Fatalf("jibMavenConfigured() with an unusable plugin = %v, %v, want false", ok, err)
+	}
+}
+
+func Test_remoteBuilderFor(t *testing.T) {
+	tests := []struct {
+		b        Builder
+		expected Builder
+		ok       bool
+	}{
+		{dockerBuilder{}, remoteBuilder{dockerfile: true}, true},
+		{packBuilder{}, remoteBuilder{}, true},
+		{composeBuilder{}, nil, false},
+		{jibMavenBuilder{}, nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := remoteBuilderFor(tt.b)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("remoteBuilderFor(%T) = %#v, %v", tt.b, got, ok)
+		}
+	}
+}
+
+func Test_Builder_Describe(t *testing.T) {
+	req := buildRequest{dir: "/src", image: "image", region: "us-central1", builderImage: "builder"}
+	tests := []struct {
+		b        Builder
+		expected string
+	}{
+		{dockerBuilder{}, "docker build --quiet --tag image /src"},
+		{composeBuilder{}, "gcloud run compose up --region us-central1"},
+		{jibMavenBuilder{}, "mvn package jib:build -Dimage=image"},
+		{packBuilder{}, "pack build image --path /src --builder builder --publish"},
+		{remoteBuilder{dockerfile: true}, "gcloud builds submit --tag image /src"},
+		{remoteBuilder{}, "gcloud builds submit --pack image=image,builder=builder /src"},
+		{dockerBuilder{docker: dockerConfig{Dockerfile: "deploy/Dockerfile", Context: "app", Target: "prod", Args: map[string]string{"B": "2", "A": "1"}, Platform: "linux/amd64"}},
+			"docker build --quiet --tag image --file /src/deploy/Dockerfile --target prod --build-arg A=1 --build-arg B=2 --platform linux/amd64 /src/app"},
+		{dockerBuilder{docker: dockerConfig{Args: map[string]string{"A": "it's 1"}}},
+			`docker build --quiet --tag image --build-arg 'A=it'\''s 1' /src`},
+		{remoteBuilder{dockerfile: true, docker: dockerConfig{Target: "prod", Args: map[string]string{"A": `it's "1"`}}},
+			`gcloud builds submit --config <(echo '{"steps": [{"name": "gcr.io/cloud-builders/docker", "args": ["build", "--tag", "image", "--target", "prod", "--build-arg", "A=it'\''s \"1\"", "."]}], "images": ["image"]}') /src`},
+	}
+	for _, tt := range tests {
+		if _, got := tt.b.Describe(req); got != tt.expected {
+			t.Errorf("%T.Describe() command = %q, want %q", tt.b, got, tt.expected)
+		}
+	}
+
+	// the paths of the docker command are joined before being formatted
+	docker := dockerBuilder{docker: dockerConfig{Dockerfile: "deploy/Dockerfile"}}
+	_, got := docker.Describe(req.display(func(s string) string { return "<" + s + ">" }))
+	if expected := "docker build --quiet --tag image --file /src/deploy/Dockerfile /src"; got != expected {
+		t.Errorf("Describe() of a displayed request = %q, want %q", got, expected)
+	}
+}
+
+func Test_strategyBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		files    map[string]string
+		// wrappers are the executable build tool wrappers
+		wrappers []string
+		expected Builder
+		wantErr  bool
+	}{
+		{"remote with Dockerfile", "remote", map[string]string{"Dockerfile": "FROM scratch"}, nil, remoteBuilder{dockerfile: true}, false},
+		{"remote with buildpacks", "remote", map[string]string{"package.json": "{}"}, nil, remoteBuilder{}, false},
+		{"jib maven over Dockerfile", "jib-maven", map[string]string{"Dockerfile": "FROM scratch", "pom.xml": jibPom}, []string{"mvnw"}, jibMavenBuilder{}, false},
+		{"jib gradle", "jib-gradle", map[string]string{"build.gradle.kts": `plugins { id("com.google.cloud.tools.jib") version "3.4.0" }`}, []string{"gradlew"}, jibGradleBuilder{}, false},
+		{"docker without Dockerfile", "docker", nil, nil, nil, true},
+		{"compose without compose file", "compose", map[string]string{"Dockerfile": "FROM scratch"}, nil, nil, true},
+		{"jib maven without plugin", "jib-maven", map[string]string{"pom.xml": "<project/>"}, []string{"mvnw"}, nil, true},
+		{"jib gradle without plugin", "jib-gradle", map[string]string{"build.gradle": "plugins { id 'java' }"}, []string{"gradlew"}, nil, true},
+		{"ko without go.mod", "ko", map[string]string{"main.go": "package main"}, nil, nil, true},
+		{"unknown", "bazel", nil, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range tt.wrappers {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := strategyBuilder(dir, build{Strategy: tt.strategy})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("strategyBuilder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("strategyBuilder() = %#v, want %#v", got, tt.expected)
+			}
+		})
+	}
+
+	missing := build{Strategy: "remote", Docker: dockerConfig{Dockerfile: "deploy/Dockerfile"}}
+	if _, err := strategyBuilder(t.TempDir(), missing); err == nil {
+		t.Error("strategyBuilder() with a missing Dockerfile should fail")
+	}
+}
+
+func Test_build_validate(t *testing.T) {
+	tru := true
+	tests := []struct {
+		name    string
+		b       build
+		wantErr bool
+	}{
+		{"detected", build{}, false},
+		{"strategy", build{Strategy: "jib-gradle"}, false},
+		{"buildpacks with builder", build{Strategy: "buildpacks", Buildpacks: buildpacks{Builder: "builder"}}, false},
+		{"unknown strategy", build{Strategy: "bazel"}, true},
+		{"strategy with skip", build{Strategy: "docker", Skip: &tru}, true},
+		{"strategy with remote", build{Strategy: "docker", Remote: &tru}, true},
+		{"docker with buildpacks builder", build{Strategy: "docker", Buildpacks: buildpacks{Builder: "builder"}}, true},
+		{"docker settings", build{Docker: dockerConfig{Dockerfile: "deploy/Dockerfile", Context: "."}}, false},
+		{"docker settings with strategy", build{Strategy: "remote", Docker: dockerConfig{Target: "prod"}}, false},
+		{"docker settings with other strategy", build{Strategy: "ko", Docker: dockerConfig{Target: "prod"}}, true},
+		{"docker settings with buildpacks", build{Docker: dockerConfig{Target: "prod"}, Buildpacks: buildpacks{Builder: "builder"}}, true},
+		{"dockerfile outside the app", build{Docker: dockerConfig{Dockerfile: "../Dockerfile"}}, true},
+		{"absolute context", build{Docker: dockerConfig{Context: "/src"}}, true},
+		{"invalid build arg", build{Docker: dockerConfig{Args: map[string]string{"A=B": "1"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.b.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_dockerConfig_expand(t *testing.T) {
+	c := dockerConfig{Target: "prod", Args: map[string]string{"VERSION": "v${MAJOR}.${MINOR}", "PLAIN": "x"}}
+	values := map[string]string{"MAJOR": "1", "MINOR": "2"}
+	got, err := c.expand(func(k string) (string, bool) {
+		v, ok := values[k]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := dockerConfig{Target: "prod", Args: map[string]string{"VERSION": "v1.2", "PLAIN": "x"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expand() = %#v, want %#v", got, expected)
+	}
+	if c.Args["VERSION"] != "v${MAJOR}.${MINOR}" {
+		t.Fatalf("expand() modified the settings: %v", c.Args)
+	}
+	_, err = (dockerConfig{Args: map[string]string{"A": "${MISSING}"}}).expand(func(string) (string, bool) { return "", false })
+	if err == nil || !strings.Contains(err.Error(), `build arg "A"`) || !strings.Contains(err.Error(), "MISSING") {
+		t.Fatalf("expand() with a missing variable error = %v, want one naming the arg and the variable", err)
+	}
+}
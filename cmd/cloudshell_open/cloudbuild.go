@@ -0,0 +1,278 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// remoteBuildAPIs are the APIs used to build on Cloud Build.
+var remoteBuildAPIs = []string{"cloudbuild.googleapis.com", "storage.googleapis.com"}
+
+// dockerAvailable checks if docker can build images in this environment.
+func dockerAvailable() bool {
+	return exec.Command("docker", "info").Run() == nil
+}
+
+const (
+	// remoteBuildTimeout is the timeout of the builds on Cloud Build.
+	remoteBuildTimeout = 30 * time.Minute
+	// remoteBuildMargin is added to remoteBuildTimeout when waiting for a
+	// build, for the time it is queued.
+	remoteBuildMargin = 10 * time.Minute
+	// stagingBucketLocation is where the staging bucket is created if it
+	// doesn't exist, the default of "gcloud builds submit".
+	stagingBucketLocation = "US"
+)
+
+// stagingBucket returns the name of the bucket the sources are uploaded to,
+// the same as "gcloud builds submit".
+func stagingBucket(project string) string {
+	r := strings.NewReplacer(":", "_", ".", "_", "google", "elgoog")
+	return r.Replace(project) + "_cloudbuild"
+}
+
+// sourceTarball writes the files of the directory as a gzipped tarball,
+// leaving out the .git directory.
+func sourceTarball(dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if fi.IsDir() && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// remoteBuildSteps returns the Cloud Build steps building the image from the
+// source, with its Dockerfile or else with the buildpacks builder. The image
+// is pushed by Cloud Build once built.
+func remoteBuildSteps(image, builderImage string, dockerfile bool, docker dockerConfig) []*cloudbuild.BuildStep {
+	if dockerfile {
+		return []*cloudbuild.BuildStep{{
+			Name: "gcr.io/cloud-builders/docker",
+			Args: append([]string{"build", "--tag", image}, docker.buildFlags(".")...),
+		}}
+	}
+	return []*cloudbuild.BuildStep{{
+		Name:       "gcr.io/k8s-skaffold/pack",
+		Entrypoint: "pack",
+		Args:       []string{"build", image, "--builder", builderImage, "--path", ".", "--network", "cloudbuild"},
+	}}
+}
+
+// remoteBuildCommand returns the gcloud command equivalent to the remote
+// build. Docker settings beyond the Dockerfile at the root need a build
+// config, which is passed inline.
+func remoteBuildCommand(dir, image, builderImage string, dockerfile bool, docker dockerConfig) string {
+	if dockerfile && docker.isSet() {
+		var args []string
+		for _, a := range remoteBuildSteps(image, builderImage, true, docker)[0].Args {
+			args = append(args, `"`+strings.NewReplacer(`\`, `\\`, `"`, `\"`, "'", `'\''`).Replace(a)+`"`)
+		}
+		return fmt.Sprintf(`gcloud builds submit --config <(echo '{"steps": [{"name": "gcr.io/cloud-builders/docker", "args": [%s]}], "images": ["%s"]}') %s`,
+			strings.Join(args, ", "), image, dir)
+	}
+	if dockerfile {
+		return fmt.Sprintf("gcloud builds submit --tag %s %s", image, dir)
+	}
+	return fmt.Sprintf("gcloud builds submit --pack image=%s,builder=%s %s", image, builderImage, dir)
+}
+
+// remoteBuild builds and pushes the image on Cloud Build from the source in
+// dir, streaming the build logs to out. It returns the digest of the pushed
+// image.
+func remoteBuild(project, dir, image, builderImage string, dockerfile bool, docker dockerConfig, labels map[string]string, out io.Writer) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteBuildTimeout+remoteBuildMargin)
+	defer cancel()
+	// the bucket is shared with "gcloud builds submit", which reuses it
+	// whatever its location
+	bucket := stagingBucket(project)
+	if err := ensureBucket(project, stagingBucketLocation, bucket, labels); err != nil {
+		return "", err
+	}
+
+	storageClient, err := storage.NewService(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize Cloud Storage client: %w", err)
+	}
+	object := fmt.Sprintf("source/%d-%s.tgz", time.Now().UnixNano(), filepath.Base(dir))
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(sourceTarball(dir, pw)) }()
+	if _, err := storageClient.Objects.Insert(bucket, &storage.Object{Name: object}).Media(pr).Context(ctx).Do(); err != nil {
+		pr.CloseWithError(err)
+		return "", fmt.Errorf("failed to upload the source to gs://%s/%s: %w", bucket, object, err)
+	}
+
+	client, err := cloudbuild.NewService(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize Cloud Build client: %w", err)
+	}
+	op, err := client.Projects.Builds.Create(project, &cloudbuild.Build{
+		Source:     &cloudbuild.Source{StorageSource: &cloudbuild.StorageSource{Bucket: bucket, Object: object}},
+		Steps:      remoteBuildSteps(image, builderImage, dockerfile, docker),
+		Images:     []string{image},
+		LogsBucket: "gs://" + bucket + "/logs",
+		Options:    &cloudbuild.BuildOptions{Logging: "GCS_ONLY"},
+		Timeout:    fmt.Sprintf("%ds", int(remoteBuildTimeout.Seconds())),
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to start the build: %w", err)
+	}
+	var meta cloudbuild.BuildOperationMetadata
+	if err := json.Unmarshal(op.Metadata, &meta); err != nil || meta.Build == nil {
+		return "", fmt.Errorf("failed to get the build of operation %s: %v", op.Name, err)
+	}
+	id := meta.Build.Id
+	fmt.Fprintf(out, "%s Logs are available at:\n\t%s\n", infoPrefix, linkLabel.Sprint(meta.Build.LogUrl))
+
+	logObject := "logs/log-" + id + ".txt"
+	var offset int64
+	for {
+		b, err := client.Projects.Builds.Get(project, id).Context(ctx).Do()
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("build %s did not finish in %s, check its logs to see why", id, remoteBuildTimeout+remoteBuildMargin)
+			}
+			return "", fmt.Errorf("failed to query build status (%s): %w", id, err)
+		}
+		// read the logs after the status, so that they are complete once done
+		if offset, err = streamLog(ctx, storageClient, bucket, logObject, offset, out); err != nil {
+			return "", err
+		}
+		switch b.Status {
+		case "SUCCESS":
+			return builtImageDigest(b, image)
+		case "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED", "EXPIRED":
+			return "", fmt.Errorf("build %s finished with status %s: %s", id, b.Status, b.StatusDetail)
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("build %s did not finish in %s, check its logs to see why", id, remoteBuildTimeout+remoteBuildMargin)
+		case <-time.After(time.Second * 2):
+		}
+	}
+}
+
+// streamLog copies the content of the log object written since offset to out,
+// and returns the new offset.
+func streamLog(ctx context.Context, client *storage.Service, bucket, object string, offset int64, out io.Writer) (int64, error) {
+	call := client.Objects.Get(bucket, object).Context(ctx)
+	call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := call.Download()
+	if err != nil {
+		var e *googleapi.Error
+		if errors.As(err, &e) && (e.Code == http.StatusNotFound || e.Code == http.StatusRequestedRangeNotSatisfiable) {
+			// not written yet, or nothing new
+			return offset, nil
+		}
+		return offset, fmt.Errorf("failed to read the build logs: %w", err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(out, resp.Body)
+	return offset + n, err
+}
+
+// builtImageDigest returns the digest of the image pushed by the build.
+func builtImageDigest(b *cloudbuild.Build, image string) (string, error) {
+	if b.Results != nil {
+		for _, i := range b.Results.Images {
+			if i.Name == image || i.Name == image+":latest" {
+				return i.Digest, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("build %s did not push image %s", b.Id, image)
+}
+
+// remoteBuilder builds and pushes the image on Cloud Build, with the
+// Dockerfile of the source or else with buildpacks.
+type remoteBuilder struct {
+	dockerfile bool
+	docker     dockerConfig
+}
+
+func (remoteBuilder) Detect(dir string) (bool, error) { return true, nil }
+
+func (remoteBuilder) Check(dir string) error { return nil }
+
+func (b remoteBuilder) Describe(req buildRequest) (string, string) {
+	return "build this application on Cloud Build", remoteBuildCommand(req.dir, req.image, req.builderImage, b.dockerfile, b.docker)
+}
+
+func (b remoteBuilder) Build(req buildRequest) (string, error) {
+	return remoteBuild(req.project, req.dir, req.image, req.builderImage, b.dockerfile, b.docker, req.labels, req.out)
+}
+
+func (remoteBuilder) NeedsPush() bool { return false }
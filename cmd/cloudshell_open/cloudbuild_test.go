@@ -0,0 +1,118 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"google.golang.org/api/cloudbuild/v1"
+)
+
+func Test_stagingBucket(t *testing.T) {
+	tests := []struct {
+		project  string
+		expected string
+	}{
+		{"my-project", "my-project_cloudbuild"},
+		{"google.com:my-project", "elgoog_com_my-project_cloudbuild"},
+	}
+	for _, tt := range tests {
+		if got := stagingBucket(tt.project); got != tt.expected {
+			t.Errorf("stagingBucket(%q) = %q, want %q", tt.project, got, tt.expected)
+		}
+	}
+}
+
+func Test_sourceTarball(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Dockerfile":      "FROM scratch",
+		"src/main.go":     "package main",
+		".git/HEAD":       "ref: refs/heads/main",
+		".dockerignore":   "*.md",
+		"src/lib/util.go": "package lib",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := sourceTarball(dir, &buf); err != nil {
+		t.Fatalf("sourceTarball() error = %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var files []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			files = append(files, hdr.Name)
+		}
+	}
+	sort.Strings(files)
+	expected := []string{".dockerignore", "Dockerfile", "src/lib/util.go", "src/main.go"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("sourceTarball() files = %v, want %v", files, expected)
+	}
+}
+
+func Test_remoteBuildSteps(t *testing.T) {
+	steps := remoteBuildSteps("image", "builder", true, dockerConfig{})
+	if len(steps) != 1 || steps[0].Name != "gcr.io/cloud-builders/docker" || !reflect.DeepEqual(steps[0].Args, []string{"build", "--tag", "image", "."}) {
+		t.Fatalf("remoteBuildSteps() with Dockerfile = %#v", steps[0])
+	}
+	steps = remoteBuildSteps("image", "builder", true, dockerConfig{Dockerfile: "deploy/Dockerfile", Context: "app", Args: map[string]string{"A": "1"}})
+	if expected := []string{"build", "--tag", "image", "--file", "deploy/Dockerfile", "--build-arg", "A=1", "app"}; !reflect.DeepEqual(steps[0].Args, expected) {
+		t.Fatalf("remoteBuildSteps() with docker settings = %v, want %v", steps[0].Args, expected)
+	}
+	steps = remoteBuildSteps("image", "builder", false, dockerConfig{})
+	if len(steps) != 1 || steps[0].Entrypoint != "pack" || !contains(steps[0].Args, "builder") {
+		t.Fatalf("remoteBuildSteps() with buildpacks = %#v", steps[0])
+	}
+}
+
+func Test_builtImageDigest(t *testing.T) {
+	b := &cloudbuild.Build{Id: "1", Results: &cloudbuild.Results{Images: []*cloudbuild.BuiltImage{
+		{Name: "other", Digest: "sha256:aaa"},
+		{Name: "image:latest", Digest: "sha256:bbb"},
+	}}}
+	if got, err := builtImageDigest(b, "image"); err != nil || got != "sha256:bbb" {
+		t.Fatalf("builtImageDigest() = %q, %v", got, err)
+	}
+	if _, err := builtImageDigest(b, "missing"); err == nil {
+		t.Fatal("builtImageDigest() succeeded for an image that wasn't pushed")
+	}
+}
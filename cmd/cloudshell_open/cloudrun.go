@@ -93,22 +93,23 @@ func serviceURL(project, name, region string) (string, error) {
 	return service.Status.Address.Url, nil
 }
 
-func envVars(project, name, region string) (map[string]struct{}, error) {
-	service, err := getService(project, name, region)
-
-	if err != nil {
-		return nil, err
-	}
-
-	existing := make(map[string]struct{})
-
-	for _, container := range service.Spec.Template.Spec.Containers {
-		for _, envVar := range container.Env {
-			existing[envVar.Name] = struct{}{}
+// serviceEnvs returns the values of the environment variables of the container
+// of the service, not including its sidecars. Variables referencing a secret are
+// returned as the reference in the same SECRET:VERSION form as the
+// --update-secrets flag of gcloud.
+func serviceEnvs(service *runapi.Service) map[string]string {
+	existing := make(map[string]string)
+
+	for _, envVar := range mainContainer(service).Env {
+		if isSecretRef(envVar) {
+			ref := envVar.ValueFrom.SecretKeyRef
+			existing[envVar.Name] = ref.Name + ":" + ref.Key
+		} else {
+			existing[envVar.Name] = envVar.Value
 		}
 	}
 
-	return existing, nil
+	return existing
 }
 
 // tryFixServiceName attempts replace the service name with a better one to
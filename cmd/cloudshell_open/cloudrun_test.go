@@ -15,7 +15,10 @@
 package main
 
 import (
+	"reflect"
 	"testing"
+
+	runapi "google.golang.org/api/run/v1"
 )
 
 func Test_tryFixServiceName(t *testing.T) {
@@ -52,3 +55,21 @@ func Test_tryFixServiceName(t *testing.T) {
 		})
 	}
 }
+
+func Test_serviceEnvs(t *testing.T) {
+	svc := &runapi.Service{Spec: &runapi.ServiceSpec{Template: &runapi.RevisionTemplate{Spec: &runapi.RevisionSpec{
+		Containers: []*runapi.Container{{Env: []*runapi.EnvVar{
+			{Name: "PLAIN", Value: "value"},
+			{Name: "EMPTY"},
+			secretEnvVar("SECRET", "svc-secret"),
+		}}},
+	}}}}
+	expected := map[string]string{
+		"PLAIN":  "value",
+		"EMPTY":  "",
+		"SECRET": "svc-secret:latest",
+	}
+	if got := serviceEnvs(svc); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("serviceEnvs() = %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/fatih/color"
+	"google.golang.org/api/googleapi"
+	runapi "google.golang.org/api/run/v1"
+	"google.golang.org/api/sqladmin/v1"
+)
+
+// cloudSQL configures the Cloud SQL database of the service.
+type cloudSQL struct {
+	// DatabaseVersion and Tier are used to create a new instance.
+	DatabaseVersion string `json:"database-version"`
+	Tier            string `json:"tier"`
+	Database        string `json:"database"`
+	User            string `json:"user"`
+}
+
+const (
+	cloudSQLInstancesAnnotation = "run.googleapis.com/cloudsql-instances"
+	cloudSQLClientRole          = "roles/cloudsql.client"
+
+	defaultCloudSQLVersion = "POSTGRES_15"
+	defaultCloudSQLTier    = "db-f1-micro"
+
+	createSQLInstanceOption = "Create a new instance"
+
+	sqlConnectionNameEnv = "INSTANCE_CONNECTION_NAME"
+	sqlDatabaseEnv       = "DB_NAME"
+	sqlUserEnv           = "DB_USER"
+	sqlPasswordEnv       = "DB_PASS"
+)
+
+var (
+	// cloudSQLEnvs are the environment variables set on services with a Cloud
+	// SQL database.
+	cloudSQLEnvs = []string{sqlConnectionNameEnv, sqlDatabaseEnv, sqlUserEnv, sqlPasswordEnv}
+
+	sqlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]{0,62}$`)
+)
+
+// validate checks the settings of the database.
+func (c cloudSQL) validate() error {
+	if v := c.DatabaseVersion; v != "" && !strings.HasPrefix(v, "POSTGRES_") && !strings.HasPrefix(v, "MYSQL_") {
+		return fmt.Errorf("unsupported database-version %q, must be a POSTGRES_ or MYSQL_ version", v)
+	}
+	if c.Database != "" && !sqlNamePattern.MatchString(c.Database) {
+		return fmt.Errorf("invalid database name %q", c.Database)
+	}
+	if c.User != "" && !sqlNamePattern.MatchString(c.User) {
+		return fmt.Errorf("invalid user name %q", c.User)
+	}
+	return nil
+}
+
+// cloudSQLSetup is the Cloud SQL instance, database and user of a service.
+type cloudSQLSetup struct {
+	instance       string
+	region         string
+	connectionName string
+	// create is set if the instance doesn't exist yet
+	create   bool
+	database string
+	user     string
+	password string
+	// setPassword is set if the password is new and needs to be stored
+	setPassword bool
+	// createUser is set if the user doesn't exist yet, and resetUser if the
+	// password of the existing user is replaced
+	createUser bool
+	resetUser  bool
+}
+
+// envs returns the K=V pairs of the environment variables describing the
+// database, except for the password.
+func (s *cloudSQLSetup) envs() []string {
+	return []string{
+		sqlConnectionNameEnv + "=" + s.connectionName,
+		sqlDatabaseEnv + "=" + s.database,
+		sqlUserEnv + "=" + s.user,
+	}
+}
+
+// sqlName returns the default database and user name for the service.
+func sqlName(service string) string {
+	return strings.ReplaceAll(service, "-", "_")
+}
+
+// sqlInstanceName returns the name of the instance created for the service.
+func sqlInstanceName(service string) string {
+	name := service
+	if len(name) > 80 {
+		name = strings.TrimRight(name[:80], "-")
+	}
+	return name + "-db"
+}
+
+// planCloudSQL picks the Cloud SQL instance of the service: the one it is
+// already connected to, an existing one the user chooses, or a new one. It
+// doesn't make any changes yet, see provisionCloudSQL.
+func planCloudSQL(project, region, service string, c cloudSQL, existing *runapi.Service, existingEnvs map[string]string) (*cloudSQLSetup, error) {
+	s := &cloudSQLSetup{
+		database: c.Database,
+		user:     c.User,
+	}
+	if s.database == "" {
+		s.database = sqlName(service)
+	}
+	if s.user == "" {
+		s.user = sqlName(service)
+	}
+
+	if conn := existingEnvs[sqlConnectionNameEnv]; existing != nil && conn != "" &&
+		hasCloudSQLInstance(existing.Spec.Template.Metadata.Annotations[cloudSQLInstancesAnnotation], conn) {
+		// already connected, keep the same password if it can be read
+		s.connectionName = conn
+		parts := strings.Split(conn, ":")
+		s.instance = parts[len(parts)-1]
+		if v := existingEnvs[sqlDatabaseEnv]; v != "" {
+			s.database = v
+		}
+		if v := existingEnvs[sqlUserEnv]; v != "" {
+			s.user = v
+		}
+		if _, ok := existingEnvs[sqlPasswordEnv]; ok {
+			if password, err := accessSecret(project, secretName(service, sqlPasswordEnv)); err == nil {
+				s.password = password
+				return s, nil
+			}
+		}
+		return s, s.planUser(project)
+	}
+
+	instances, err := listSQLInstances(project)
+	if err != nil {
+		return nil, err
+	}
+	var chosen *sqladmin.DatabaseInstance
+	if len(instances) > 0 {
+		if chosen, err = promptSQLInstance(instances); err != nil {
+			return nil, err
+		}
+	}
+	if chosen != nil {
+		s.instance = chosen.Name
+		s.region = chosen.Region
+		s.connectionName = chosen.ConnectionName
+		return s, s.planUser(project)
+	}
+	s.instance = sqlInstanceName(service)
+	s.region = region
+	s.connectionName = fmt.Sprintf("%s:%s:%s", project, region, s.instance)
+	s.create = true
+	s.createUser = true
+	return s, s.generatePassword()
+}
+
+// planUser gets the password of the user on an existing instance. A missing
+// user is created with a new password. Other clients of the instance may use
+// an existing user, so its password is asked for, and only reset if the user
+// confirms it.
+func (s *cloudSQLSetup) planUser(project string) error {
+	exists, err := sqlUserExists(project, s.instance, s.user)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		s.createUser = true
+		return s.generatePassword()
+	}
+	password, err := promptSQLUserPassword(s.instance, s.user)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		s.resetUser = true
+		return s.generatePassword()
+	}
+	s.password = password
+	s.setPassword = true
+	return nil
+}
+
+func sqlUserExists(project, instance, user string) (bool, error) {
+	client, err := sqladmin.NewService(context.TODO())
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize Cloud SQL Admin client: %w", err)
+	}
+	if _, err := client.Users.Get(project, instance, user).Do(); err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get database user %s: %w", user, err)
+	}
+	return true, nil
+}
+
+// promptSQLUserPassword asks for the current password of the existing user,
+// and returns an empty password if the user confirms resetting it instead.
+func promptSQLUserPassword(instance, user string) (string, error) {
+	fmt.Printf("%s Database user %s already exists on Cloud SQL instance %s, and other applications may use it.\n",
+		infoPrefix, color.CyanString(user), color.CyanString(instance))
+	for {
+		var password string
+		if err := survey.AskOne(&survey.Password{
+			Message: fmt.Sprintf("Password of database user %s (leave empty to reset it)", user),
+		}, &password, surveyIconOpts); err != nil {
+			return "", fmt.Errorf("could not prompt for the password of database user %s: %+v", user, err)
+		}
+		if password == "" {
+			break
+		}
+		// a mistyped password would only show up as connection errors of the
+		// deployed service
+		var confirmation string
+		if err := survey.AskOne(&survey.Password{
+			Message: fmt.Sprintf("Confirm the password of database user %s", user),
+		}, &confirmation, surveyIconOpts); err != nil {
+			return "", fmt.Errorf("could not prompt for the password of database user %s: %+v", user, err)
+		}
+		if confirmation == password {
+			return password, nil
+		}
+		fmt.Printf("%s The passwords don't match, try again.\n", errorPrefix)
+	}
+	var reset bool
+	if err := survey.AskOne(&survey.Confirm{
+		Default: false,
+		Message: fmt.Sprintf("Reset the password of database user %s? Other clients using it will lose access.", user),
+	}, &reset, surveyIconOpts); err != nil {
+		return "", fmt.Errorf("could not prompt for confirmation: %+v", err)
+	}
+	if !reset {
+		return "", fmt.Errorf("the password of database user %s is needed, or set cloudsql.user to use another user", user)
+	}
+	return "", nil
+}
+
+func (s *cloudSQLSetup) generatePassword() error {
+	// no symbols so that the password can be used in connection URLs as is
+	password, err := randPassword(generatorSpec{Length: 32, Charsets: []string{"lower", "upper", "digits"}})
+	if err != nil {
+		return fmt.Errorf("failed to generate the database password: %w", err)
+	}
+	s.password = password
+	s.setPassword = true
+	return nil
+}
+
+func listSQLInstances(project string) ([]*sqladmin.DatabaseInstance, error) {
+	client, err := sqladmin.NewService(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Cloud SQL Admin client: %w", err)
+	}
+	var out []*sqladmin.DatabaseInstance
+	if err := client.Instances.List(project).Pages(context.TODO(), func(resp *sqladmin.InstancesListResponse) error {
+		for _, i := range resp.Items {
+			if i.InstanceType == "CLOUD_SQL_INSTANCE" || i.InstanceType == "" {
+				out = append(out, i)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list Cloud SQL instances: %w", err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// promptSQLInstance lets the user choose one of the instances, and returns nil
+// if they choose to create a new one instead.
+func promptSQLInstance(instances []*sqladmin.DatabaseInstance) (*sqladmin.DatabaseInstance, error) {
+	options := []string{createSQLInstanceOption}
+	byOption := make(map[string]*sqladmin.DatabaseInstance)
+	for _, i := range instances {
+		o := fmt.Sprintf("%s (%s, %s)", i.Name, i.DatabaseVersion, i.Region)
+		options = append(options, o)
+		byOption[o] = i
+	}
+	var choice string
+	if err := survey.AskOne(&survey.Select{
+		Message: "Choose the Cloud SQL instance of the application:",
+		Options: options,
+	}, &choice,
+		surveyIconOpts,
+	); err != nil {
+		return nil, fmt.Errorf("could not choose a Cloud SQL instance: %+v", err)
+	}
+	return byOption[choice], nil
+}
+
+const (
+	// sqlInstanceTimeout is how long to wait for a Cloud SQL instance to be
+	// created, which usually takes several minutes.
+	sqlInstanceTimeout = 30 * time.Minute
+	// sqlOperationTimeout is how long to wait for the other Cloud SQL
+	// operations.
+	sqlOperationTimeout = 5 * time.Minute
+)
+
+// provisionCloudSQL creates the instance with the labels, the database if it
+// doesn't already exist, and the user or its new password as planned.
+func provisionCloudSQL(project string, c cloudSQL, s *cloudSQLSetup, labels map[string]string) error {
+	client, err := sqladmin.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Cloud SQL Admin client: %w", err)
+	}
+
+	if s.create {
+		version := c.DatabaseVersion
+		if version == "" {
+			version = defaultCloudSQLVersion
+		}
+		tier := c.Tier
+		if tier == "" {
+			tier = defaultCloudSQLTier
+		}
+		op, err := client.Instances.Insert(project, &sqladmin.DatabaseInstance{
+			Name:            s.instance,
+			Region:          s.region,
+			DatabaseVersion: version,
+			Settings: &sqladmin.Settings{
+				Tier:       tier,
+				Edition:    "ENTERPRISE",
+				UserLabels: labels,
+			},
+		}).Do()
+		if err != nil {
+			return fmt.Errorf("failed to create Cloud SQL instance %s: %w", s.instance, err)
+		}
+		if err := waitSQLOperation(client, project, op, sqlInstanceTimeout); err != nil {
+			return fmt.Errorf("failed to create Cloud SQL instance %s: %w", s.instance, err)
+		}
+	}
+
+	if _, err := client.Databases.Get(project, s.instance, s.database).Do(); err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get database %s: %w", s.database, err)
+		}
+		op, err := client.Databases.Insert(project, s.instance, &sqladmin.Database{Name: s.database}).Do()
+		if err != nil {
+			return fmt.Errorf("failed to create database %s: %w", s.database, err)
+		}
+		if err := waitSQLOperation(client, project, op, sqlOperationTimeout); err != nil {
+			return fmt.Errorf("failed to create database %s: %w", s.database, err)
+		}
+	}
+
+	user := &sqladmin.User{Name: s.user, Password: s.password}
+	var op *sqladmin.Operation
+	switch {
+	case s.createUser:
+		op, err = client.Users.Insert(project, s.instance, user).Do()
+		if err != nil {
+			return fmt.Errorf("failed to create database user %s: %w", s.user, err)
+		}
+	case s.resetUser:
+		op, err = client.Users.Update(project, s.instance, user).Name(s.user).Do()
+		if err != nil {
+			return fmt.Errorf("failed to set the password of database user %s: %w", s.user, err)
+		}
+	default:
+		return nil
+	}
+	if err := waitSQLOperation(client, project, op, sqlOperationTimeout); err != nil {
+		return fmt.Errorf("failed to set up database user %s: %w", s.user, err)
+	}
+	return nil
+}
+
+// waitSQLOperation waits until the Cloud SQL operation is done, at most for
+// the wait duration.
+func waitSQLOperation(client *sqladmin.Service, project string, op *sqladmin.Operation, wait time.Duration) error {
+	opID := op.Name
+	deadline := time.Now().Add(wait)
+	for op.Status != "DONE" {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("operation %s did not complete in %s, check Cloud Console for its status", opID, wait)
+		}
+		time.Sleep(time.Second * 5)
+		var err error
+		op, err = client.Operations.Get(project, opID).Do()
+		if err != nil {
+			return fmt.Errorf("failed to query operation status (%s): %w", opID, err)
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		var msgs []string
+		for _, e := range op.Error.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("operation %s failed: %s", op.Name, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var e *googleapi.Error
+	return errors.As(err, &e) && e.Code == http.StatusNotFound
+}
+
+// withCloudSQLInstance connects the revisions of the Service to the Cloud SQL
+// instance.
+func withCloudSQLInstance(connectionName string) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		meta := svc.Spec.Template.Metadata
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		meta.Annotations[cloudSQLInstancesAnnotation] = addCloudSQLInstance(meta.Annotations[cloudSQLInstancesAnnotation], connectionName)
+	}
+}
+
+// addCloudSQLInstance adds the connection name to the comma-separated list of
+// instances unless it's already there.
+func addCloudSQLInstance(instances, connectionName string) string {
+	if instances == "" {
+		return connectionName
+	}
+	if hasCloudSQLInstance(instances, connectionName) {
+		return instances
+	}
+	return instances + "," + connectionName
+}
+
+// hasCloudSQLInstance checks if the comma-separated list of instances has the
+// connection name.
+func hasCloudSQLInstance(instances, connectionName string) bool {
+	for _, i := range strings.Split(instances, ",") {
+		if strings.TrimSpace(i) == connectionName {
+			return true
+		}
+	}
+	return false
+}
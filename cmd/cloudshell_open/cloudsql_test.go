@@ -0,0 +1,83 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_sqlNames(t *testing.T) {
+	if got := sqlName("my-app"); got != "my_app" {
+		t.Errorf("sqlName() = %q", got)
+	}
+	if got := sqlInstanceName("my-app"); got != "my-app-db" {
+		t.Errorf("sqlInstanceName() = %q", got)
+	}
+	if got := sqlInstanceName(strings.Repeat("a", 79) + "-b"); got != strings.Repeat("a", 79)+"-db" {
+		t.Errorf("sqlInstanceName() = %q", got)
+	}
+}
+
+func Test_cloudSQL_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       cloudSQL
+		wantErr bool
+	}{
+		{"empty", cloudSQL{}, false},
+		{"postgres", cloudSQL{DatabaseVersion: "POSTGRES_16", Tier: "db-g1-small"}, false},
+		{"mysql", cloudSQL{DatabaseVersion: "MYSQL_8_0", Database: "app", User: "app_user"}, false},
+		{"sql server", cloudSQL{DatabaseVersion: "SQLSERVER_2019_EXPRESS"}, true},
+		{"invalid database", cloudSQL{Database: "my db"}, true},
+		{"invalid user", cloudSQL{User: "1'; DROP"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_addCloudSQLInstance(t *testing.T) {
+	tests := []struct {
+		instances, conn, want string
+	}{
+		{"", "p:r:db", "p:r:db"},
+		{"p:r:db", "p:r:db", "p:r:db"},
+		{"p:r:other", "p:r:db", "p:r:other,p:r:db"},
+		{"p:r:db, p:r:other", "p:r:db", "p:r:db, p:r:other"},
+	}
+	for _, tt := range tests {
+		if got := addCloudSQLInstance(tt.instances, tt.conn); got != tt.want {
+			t.Errorf("addCloudSQLInstance(%q, %q) = %q, want %q", tt.instances, tt.conn, got, tt.want)
+		}
+	}
+}
+
+func Test_withCloudSQLInstance(t *testing.T) {
+	svc := newService("svc", "project", "image", nil, nil, envSync{}, options{}, withCloudSQLInstance("p:r:db"))
+	if got := svc.Spec.Template.Metadata.Annotations[cloudSQLInstancesAnnotation]; got != "p:r:db" {
+		t.Fatalf("newService() %s = %q", cloudSQLInstancesAnnotation, got)
+	}
+
+	svc.Spec.Template.Metadata.Annotations[cloudSQLInstancesAnnotation] = "p:r:other"
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{}, withCloudSQLInstance("p:r:db"))
+	if got := svc.Spec.Template.Metadata.Annotations[cloudSQLInstancesAnnotation]; got != "p:r:other,p:r:db" {
+		t.Fatalf("patchService() %s = %q", cloudSQLInstancesAnnotation, got)
+	}
+}
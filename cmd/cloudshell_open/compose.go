@@ -26,3 +26,31 @@ func composeFileExists(dir string) (bool, error) {
 	}
 	return false, nil
 }
+
+// composeBuilder deploys the application with Docker Compose, which builds
+// and deploys its services itself.
+type composeBuilder struct{}
+
+func (composeBuilder) Detect(dir string) (bool, error) { return composeFileExists(dir) }
+
+func (composeBuilder) Check(dir string) error {
+	if ok, err := composeFileExists(dir); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("there is no compose.yaml or docker-compose.yaml in %s", dir)
+	}
+	if _, err := exec.LookPath("gcloud"); err != nil {
+		return fmt.Errorf("gcloud is not installed")
+	}
+	return nil
+}
+
+func (composeBuilder) Describe(req buildRequest) (string, string) {
+	return "deploy this application with Docker Compose", fmt.Sprintf("gcloud run compose up --region %s", req.region)
+}
+
+func (composeBuilder) Build(req buildRequest) (string, error) {
+	return "", composeRunUp(req.dir, req.region)
+}
+
+func (composeBuilder) NeedsPush() bool { return false }
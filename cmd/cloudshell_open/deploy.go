@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,9 +24,23 @@ func parseEnv(envs []string) map[string]string {
 	return out
 }
 
+// managedEnvsAnnotation is the annotation of the service listing the environment
+// variables that are managed by the button, i.e. declared in app.json.
+const managedEnvsAnnotation = "cloud-run-button/managed-envs"
+
+// envSync describes the environment variables managed by the button.
+type envSync struct {
+	// managed are the names of the variables recorded as managed on the service.
+	managed []string
+	// remove are the names of the variables to remove from the service.
+	remove []string
+}
+
 // deploy reimplements the "gcloud run deploy" command, including setting IAM policy and
-// waiting for Service to be Ready.
-func deploy(project, name, image, region string, envs []string, options options) (string, error) {
+// waiting for Service to be Ready. secrets maps environment variable names to the
+// Secret Manager secrets holding their values, and apply makes further changes to
+// the new or updated Service.
+func deploy(project, name, image, region string, envs []string, secrets map[string]string, sync envSync, options options, apply ...func(*runapi.Service)) (string, error) {
 	envVars := parseEnv(envs)
 
 	client, err := runClient(region)
@@ -32,10 +48,11 @@ func deploy(project, name, image, region string, envs []string, options options)
 		return "", fmt.Errorf("failed to initialize Run API client: %w", err)
 	}
 
+	wait := time.Minute * 4
 	svc, err := getService(project, name, region)
 	if err == nil {
 		// existing service
-		svc = patchService(svc, envVars, image, options)
+		svc = patchService(svc, envVars, secrets, sync, image, options, apply...)
 		_, err = client.Namespaces.Services.ReplaceService("namespaces/"+project+"/services/"+name, svc).Do()
 		if err != nil {
 			if e, ok := err.(*googleapi.Error); ok {
@@ -43,9 +60,10 @@ func deploy(project, name, image, region string, envs []string, options options)
 			}
 			return "", fmt.Errorf("failed to deploy to existing Service: %w", err)
 		}
+		wait = readyTimeout(svc)
 	} else {
 		// new service
-		svc := newService(name, project, image, envVars, options)
+		svc := newService(name, project, image, envVars, secrets, sync, options, apply...)
 		_, err = client.Namespaces.Services.Create("namespaces/"+project, svc).Do()
 		if err != nil {
 			if e, ok := err.(*googleapi.Error); ok {
@@ -53,6 +71,7 @@ func deploy(project, name, image, region string, envs []string, options options)
 			}
 			return "", fmt.Errorf("failed to deploy a new Service: %w", err)
 		}
+		wait = readyTimeout(svc)
 	}
 
 	if options.AllowUnauthenticated == nil || *options.AllowUnauthenticated {
@@ -61,7 +80,7 @@ func deploy(project, name, image, region string, envs []string, options options)
 		}
 	}
 
-	if err := waitReady(project, name, region); err != nil {
+	if err := waitReady(project, name, region, wait); err != nil {
 		return "", err
 	}
 
@@ -98,11 +117,14 @@ func optionsToContainerSpec(options options) *runapi.ContainerPort {
 }
 
 // newService initializes a new Knative Service object with given properties.
-func newService(name, project, image string, envs map[string]string, options options) *runapi.Service {
+func newService(name, project, image string, envs, secrets map[string]string, sync envSync, options options, apply ...func(*runapi.Service)) *runapi.Service {
 	var envVars []*runapi.EnvVar
 	for k, v := range envs {
 		envVars = append(envVars, &runapi.EnvVar{Name: k, Value: v})
 	}
+	for k, v := range secrets {
+		envVars = append(envVars, secretEnvVar(k, v))
+	}
 
 	svc := &runapi.Service{
 		ApiVersion: "serving.knative.dev/v1",
@@ -120,12 +142,16 @@ func newService(name, project, image string, envs map[string]string, options opt
 				},
 				Spec: &runapi.RevisionSpec{
 					ContainerConcurrency: int64(options.Concurrency),
+					TimeoutSeconds:       int64(options.Timeout),
 					Containers: []*runapi.Container{
 						{
-							Image:     image,
-							Env:       envVars,
-							Resources: optionsToResourceRequirements(options),
-							Ports:     []*runapi.ContainerPort{optionsToContainerSpec(options)},
+							Image:      image,
+							Command:    options.Command,
+							Args:       options.Args,
+							WorkingDir: options.WorkingDir,
+							Env:        envVars,
+							Resources:  optionsToResourceRequirements(options),
+							Ports:      []*runapi.ContainerPort{optionsToContainerSpec(options)},
 						},
 					},
 				},
@@ -137,7 +163,13 @@ func newService(name, project, image string, envs map[string]string, options opt
 
 	applyMeta(svc.Metadata, image)
 	applyMeta(svc.Spec.Template.Metadata, image)
-	applyScaleMeta(svc.Spec.Template.Metadata, "maxScale", options.MaxInstances)
+	applyOptionsMeta(svc.Spec.Template.Metadata, options)
+	applyNetworkMeta(svc.Metadata, svc.Spec.Template.Metadata, options)
+	applyManagedEnvsMeta(svc.Metadata, sync.managed)
+
+	for _, f := range apply {
+		f(svc)
+	}
 
 	return svc
 }
@@ -151,11 +183,107 @@ func applyMeta(meta *runapi.ObjectMeta, userImage string) {
 	meta.Annotations["run.googleapis.com/client-name"] = "cloud-run-button"
 }
 
-// applyScaleMeta optional annotations for scale commands
-func applyScaleMeta(meta *runapi.ObjectMeta, scaleType string, scaleValue int) {
-	if scaleValue > 0 {
-		meta.Annotations["autoscaling.knative.dev"+scaleType] = strconv.Itoa(scaleValue)
+// applyManagedEnvsMeta records the managed environment variables in the specified Metadata.
+func applyManagedEnvsMeta(meta *runapi.ObjectMeta, managed []string) {
+	if len(managed) == 0 {
+		delete(meta.Annotations, managedEnvsAnnotation)
+		return
+	}
+	names := append([]string(nil), managed...)
+	sort.Strings(names)
+	meta.Annotations[managedEnvsAnnotation] = strings.Join(names, ",")
+}
+
+// managedEnvs returns the environment variables recorded as managed on the service.
+func managedEnvs(svc *runapi.Service) []string {
+	if svc.Metadata == nil || svc.Metadata.Annotations[managedEnvsAnnotation] == "" {
+		return nil
+	}
+	return strings.Split(svc.Metadata.Annotations[managedEnvsAnnotation], ",")
+}
+
+// removedEnvs returns the environment variables of the service that were managed
+// by the button, but are no longer declared. Variables that were not managed by
+// the button are never returned.
+func removedEnvs(svc *runapi.Service, declared []string) []*runapi.EnvVar {
+	isDeclared := make(map[string]bool)
+	for _, k := range declared {
+		isDeclared[k] = true
+	}
+	wasManaged := make(map[string]bool)
+	for _, k := range managedEnvs(svc) {
+		wasManaged[k] = true
+	}
+	var out []*runapi.EnvVar
+	for _, e := range mainContainer(svc).Env {
+		if wasManaged[e.Name] && !isDeclared[e.Name] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// applyOptionsMeta applies the annotations of the options to the specified revision Metadata
+func applyOptionsMeta(meta *runapi.ObjectMeta, options options) {
+	applyScaleMeta(meta, "minScale", options.MinInstances)
+	var maxScale *int
+	if options.MaxInstances > 0 {
+		maxScale = &options.MaxInstances
+	}
+	applyScaleMeta(meta, "maxScale", maxScale)
+	applyBoolMeta(meta, "run.googleapis.com/startup-cpu-boost", options.CPUBoost)
+	applyBoolMeta(meta, "run.googleapis.com/cpu-throttling", options.CPUThrottling)
+}
+
+// applyNetworkMeta applies the networking annotations of the options: ingress to
+// the service Metadata, and VPC access to the revision Metadata
+func applyNetworkMeta(serviceMeta, revisionMeta *runapi.ObjectMeta, options options) {
+	if options.Ingress != "" {
+		serviceMeta.Annotations["run.googleapis.com/ingress"] = options.Ingress
+	}
+
+	// a revision uses either a connector or Direct VPC egress
+	if options.VPCConnector != "" {
+		revisionMeta.Annotations["run.googleapis.com/vpc-access-connector"] = options.VPCConnector
+		delete(revisionMeta.Annotations, "run.googleapis.com/network-interfaces")
+	} else if options.Network != "" || options.Subnet != "" {
+		revisionMeta.Annotations["run.googleapis.com/network-interfaces"] = networkInterfaces(options)
+		delete(revisionMeta.Annotations, "run.googleapis.com/vpc-access-connector")
+	}
+	if options.VPCEgress != "" {
+		revisionMeta.Annotations["run.googleapis.com/vpc-access-egress"] = options.VPCEgress
+	}
+}
+
+// networkInterfaces returns the value of the annotation for Direct VPC egress
+func networkInterfaces(options options) string {
+	b, _ := json.Marshal([]struct {
+		Network    string   `json:"network,omitempty"`
+		Subnetwork string   `json:"subnetwork,omitempty"`
+		Tags       []string `json:"tags,omitempty"`
+	}{{options.Network, options.Subnet, options.NetworkTags}})
+	return string(b)
+}
+
+// applyScaleMeta sets the annotation of the optional scale value, removing it
+// when the value is unset so a redeploy drops a scale dropped from app.json.
+func applyScaleMeta(meta *runapi.ObjectMeta, scaleType string, scaleValue *int) {
+	key := "autoscaling.knative.dev/" + scaleType
+	if scaleValue == nil {
+		delete(meta.Annotations, key)
+		return
+	}
+	meta.Annotations[key] = strconv.Itoa(*scaleValue)
+}
+
+// applyBoolMeta sets the annotation to the optional boolean value
+// or removes it when the value is unset.
+func applyBoolMeta(meta *runapi.ObjectMeta, key string, value *bool) {
+	if value == nil {
+		delete(meta.Annotations, key)
+		return
 	}
+	meta.Annotations[key] = strconv.FormatBool(*value)
 }
 
 // generateRevisionName attempts to generate a random revision name that is alphabetically increasing but also has
@@ -171,22 +299,48 @@ func generateRevisionName(name string, objectGeneration int64) string {
 }
 
 // patchService modifies an existing Service with requested changes.
-func patchService(svc *runapi.Service, envs map[string]string, image string, options options) *runapi.Service {
+func patchService(svc *runapi.Service, envs, secrets map[string]string, sync envSync, image string, options options, apply ...func(*runapi.Service)) *runapi.Service {
+	container := mainContainer(svc)
+
 	// merge env vars
-	svc.Spec.Template.Spec.Containers[0].Env = mergeEnvs(svc.Spec.Template.Spec.Containers[0].Env, envs)
+	container.Env = mergeEnvs(container.Env, envs, secrets)
+
+	// remove env vars no longer managed
+	container.Env = removeEnvs(container.Env, sync.remove)
 
 	// update container image
-	svc.Spec.Template.Spec.Containers[0].Image = image
+	container.Image = image
 
 	// update container port
-	svc.Spec.Template.Spec.Containers[0].Ports[0] = optionsToContainerSpec(options)
+	container.Ports = []*runapi.ContainerPort{optionsToContainerSpec(options)}
+
+	// update container entrypoint, an unset option restores the one of the image
+	container.Command = options.Command
+	container.Args = options.Args
+	container.WorkingDir = options.WorkingDir
 
 	// apply metadata annotations
 	applyMeta(svc.Metadata, image)
 	applyMeta(svc.Spec.Template.Metadata, image)
 
-	// apply scale metadata annotations
-	applyScaleMeta(svc.Spec.Template.Metadata, "maxScale", options.MaxInstances)
+	// apply scale and cpu metadata annotations
+	applyOptionsMeta(svc.Spec.Template.Metadata, options)
+
+	// apply networking metadata annotations
+	applyNetworkMeta(svc.Metadata, svc.Spec.Template.Metadata, options)
+
+	// update request timeout
+	if options.Timeout > 0 {
+		svc.Spec.Template.Spec.TimeoutSeconds = int64(options.Timeout)
+	}
+
+	// record the managed env vars
+	applyManagedEnvsMeta(svc.Metadata, sync.managed)
+
+	// apply further changes
+	for _, f := range apply {
+		f(svc)
+	}
 
 	// update revision name
 	svc.Spec.Template.Metadata.Name = generateRevisionName(svc.Metadata.Name, svc.Metadata.Generation)
@@ -194,11 +348,20 @@ func patchService(svc *runapi.Service, envs map[string]string, image string, opt
 	return svc
 }
 
-// mergeEnvs updates variables in existing, and adds missing ones.
-func mergeEnvs(existing []*runapi.EnvVar, env map[string]string) []*runapi.EnvVar {
+// mergeEnvs updates variables in existing, and adds missing ones. Variables in
+// secrets are set to reference the specified secret, and existing secret
+// references are never overwritten with a plain value.
+func mergeEnvs(existing []*runapi.EnvVar, env, secrets map[string]string) []*runapi.EnvVar {
+	seen := make(map[string]bool)
 	for i, ee := range existing {
+		if s, ok := secrets[ee.Name]; ok {
+			existing[i] = secretEnvVar(ee.Name, s)
+			seen[ee.Name] = true
+		}
 		if v, ok := env[ee.Name]; ok {
-			existing[i].Value = v
+			if !isSecretRef(ee) {
+				existing[i].Value = v
+			}
 			delete(env, ee.Name)
 		}
 	}
@@ -206,12 +369,58 @@ func mergeEnvs(existing []*runapi.EnvVar, env map[string]string) []*runapi.EnvVa
 	for k, v := range env {
 		existing = append(existing, &runapi.EnvVar{Name: k, Value: v})
 	}
+	for k, v := range secrets {
+		if !seen[k] {
+			existing = append(existing, secretEnvVar(k, v))
+		}
+	}
 	return existing
 }
 
+// withServiceAccount makes the revisions of the Service run as the specified
+// service account.
+func withServiceAccount(email string) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		svc.Spec.Template.Spec.ServiceAccountName = email
+	}
+}
+
+// removeEnvs removes the specified variables from existing.
+func removeEnvs(existing []*runapi.EnvVar, names []string) []*runapi.EnvVar {
+	if len(names) == 0 {
+		return existing
+	}
+	remove := make(map[string]bool)
+	for _, k := range names {
+		remove[k] = true
+	}
+	var out []*runapi.EnvVar
+	for _, e := range existing {
+		if !remove[e.Name] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// secretEnvVar returns an environment variable referencing the latest version
+// of the specified Secret Manager secret.
+func secretEnvVar(name, secret string) *runapi.EnvVar {
+	return &runapi.EnvVar{
+		Name: name,
+		ValueFrom: &runapi.EnvVarSource{
+			SecretKeyRef: &runapi.SecretKeySelector{Name: secret, Key: "latest"},
+		},
+	}
+}
+
+// isSecretRef checks if the environment variable references a secret.
+func isSecretRef(e *runapi.EnvVar) bool {
+	return e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil
+}
+
 // waitReady waits until the specified service reaches Ready status
-func waitReady(project, name, region string) error {
-	wait := time.Minute * 4
+func waitReady(project, name, region string, wait time.Duration) error {
 	deadline := time.Now().Add(wait)
 	for time.Now().Before(deadline) {
 		svc, err := getService(project, name, region)
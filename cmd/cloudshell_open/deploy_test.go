@@ -0,0 +1,273 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+func Test_mergeEnvs(t *testing.T) {
+	existing := []*runapi.EnvVar{
+		{Name: "PLAIN", Value: "old"},
+		{Name: "KEPT", Value: "kept"},
+		secretEnvVar("SECRET_REF", "svc-secret-ref"),
+		{Name: "BECOMES_SECRET", Value: "plain"},
+	}
+	got := mergeEnvs(existing,
+		map[string]string{"PLAIN": "new", "SECRET_REF": "overwritten", "NEW": "v"},
+		map[string]string{"BECOMES_SECRET": "svc-becomes-secret", "NEW_SECRET": "svc-new-secret"})
+
+	expected := []*runapi.EnvVar{
+		{Name: "BECOMES_SECRET", ValueFrom: &runapi.EnvVarSource{
+			SecretKeyRef: &runapi.SecretKeySelector{Name: "svc-becomes-secret", Key: "latest"}}},
+		{Name: "KEPT", Value: "kept"},
+		{Name: "NEW", Value: "v"},
+		{Name: "NEW_SECRET", ValueFrom: &runapi.EnvVarSource{
+			SecretKeyRef: &runapi.SecretKeySelector{Name: "svc-new-secret", Key: "latest"}}},
+		{Name: "PLAIN", Value: "new"},
+		{Name: "SECRET_REF", ValueFrom: &runapi.EnvVarSource{
+			SecretKeyRef: &runapi.SecretKeySelector{Name: "svc-secret-ref", Key: "latest"}}},
+	}
+	sortEnvVars(got)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mergeEnvs() = %s, want %s", envVarsString(got), envVarsString(expected))
+	}
+}
+
+func Test_newService_secrets(t *testing.T) {
+	svc := newService("svc", "project", "image", map[string]string{"PLAIN": "v"},
+		map[string]string{"SECRET": "svc-secret"}, envSync{}, options{})
+	got := svc.Spec.Template.Spec.Containers[0].Env
+	sortEnvVars(got)
+	expected := []*runapi.EnvVar{
+		{Name: "PLAIN", Value: "v"},
+		secretEnvVar("SECRET", "svc-secret"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("wrong env vars: got=%s, want=%s", envVarsString(got), envVarsString(expected))
+	}
+}
+
+func Test_patchService_sync(t *testing.T) {
+	svc := &runapi.Service{
+		Metadata: &runapi.ObjectMeta{Name: "svc", Annotations: map[string]string{
+			managedEnvsAnnotation: "DROPPED,DROPPED_SECRET,KEPT",
+		}},
+		Spec: &runapi.ServiceSpec{Template: &runapi.RevisionTemplate{
+			Metadata: &runapi.ObjectMeta{},
+			Spec: &runapi.RevisionSpec{Containers: []*runapi.Container{{
+				Env: []*runapi.EnvVar{
+					{Name: "KEPT", Value: "v"},
+					{Name: "DROPPED", Value: "v"},
+					secretEnvVar("DROPPED_SECRET", "svc-dropped-secret"),
+					{Name: "MANUAL", Value: "v"},
+				},
+				Ports: []*runapi.ContainerPort{{}},
+			}}},
+		}},
+	}
+	declared := []string{"NEW", "KEPT"}
+
+	removed := removedEnvs(svc, declared)
+	var names []string
+	for _, e := range removed {
+		names = append(names, e.Name)
+	}
+	if expected := []string{"DROPPED", "DROPPED_SECRET"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("removedEnvs() = %v, want %v", names, expected)
+	}
+
+	svc = patchService(svc, map[string]string{"NEW": "v"}, nil, envSync{managed: declared, remove: names}, "image", options{})
+	got := svc.Spec.Template.Spec.Containers[0].Env
+	sortEnvVars(got)
+	expected := []*runapi.EnvVar{
+		{Name: "KEPT", Value: "v"},
+		{Name: "MANUAL", Value: "v"},
+		{Name: "NEW", Value: "v"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("wrong env vars: got=%s, want=%s", envVarsString(got), envVarsString(expected))
+	}
+	if got, expected := svc.Metadata.Annotations[managedEnvsAnnotation], "KEPT,NEW"; got != expected {
+		t.Fatalf("wrong %s annotation: got=%q, want=%q", managedEnvsAnnotation, got, expected)
+	}
+}
+
+func Test_removedEnvs_unmanaged(t *testing.T) {
+	svc := &runapi.Service{
+		Metadata: &runapi.ObjectMeta{},
+		Spec: &runapi.ServiceSpec{Template: &runapi.RevisionTemplate{Spec: &runapi.RevisionSpec{
+			Containers: []*runapi.Container{{Env: []*runapi.EnvVar{{Name: "MANUAL", Value: "v"}}}},
+		}}},
+	}
+	if got := removedEnvs(svc, nil); len(got) != 0 {
+		t.Fatalf("removedEnvs() = %s, want none", envVarsString(got))
+	}
+}
+
+func Test_newService_options(t *testing.T) {
+	svc := newService("svc", "project", "image", nil, nil, envSync{}, options{
+		MinInstances:  &one,
+		MaxInstances:  5,
+		Timeout:       900,
+		CPUBoost:      &tru,
+		CPUThrottling: &fals,
+	})
+	annotations := svc.Spec.Template.Metadata.Annotations
+	for k, v := range map[string]string{
+		"autoscaling.knative.dev/minScale":     "1",
+		"autoscaling.knative.dev/maxScale":     "5",
+		"run.googleapis.com/startup-cpu-boost": "true",
+		"run.googleapis.com/cpu-throttling":    "false",
+	} {
+		if annotations[k] != v {
+			t.Errorf("annotation %s = %q, want %q", k, annotations[k], v)
+		}
+	}
+	if got := svc.Spec.Template.Spec.TimeoutSeconds; got != 900 {
+		t.Errorf("TimeoutSeconds = %d, want 900", got)
+	}
+
+	svc = newService("svc", "project", "image", nil, nil, envSync{}, options{})
+	for _, k := range []string{"autoscaling.knative.dev/minScale", "autoscaling.knative.dev/maxScale",
+		"run.googleapis.com/startup-cpu-boost", "run.googleapis.com/cpu-throttling"} {
+		if v, ok := svc.Spec.Template.Metadata.Annotations[k]; ok {
+			t.Errorf("unexpected annotation %s = %q", k, v)
+		}
+	}
+}
+
+func Test_patchService_options(t *testing.T) {
+	svc := newService("svc", "project", "image", nil, nil, envSync{}, options{
+		MinInstances:  &two,
+		MaxInstances:  5,
+		Timeout:       900,
+		CPUThrottling: &fals,
+	})
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{MinInstances: &zero, CPUBoost: &tru})
+
+	annotations := svc.Spec.Template.Metadata.Annotations
+	for k, v := range map[string]string{
+		"autoscaling.knative.dev/minScale":     "0",
+		"run.googleapis.com/startup-cpu-boost": "true",
+	} {
+		if annotations[k] != v {
+			t.Errorf("annotation %s = %q, want %q", k, annotations[k], v)
+		}
+	}
+	// options dropped from app.json are removed on redeploy
+	for _, k := range []string{"autoscaling.knative.dev/maxScale", "run.googleapis.com/cpu-throttling"} {
+		if v, ok := annotations[k]; ok {
+			t.Errorf("unexpected annotation %s = %q", k, v)
+		}
+	}
+	if got := svc.Spec.Template.Spec.TimeoutSeconds; got != 900 {
+		t.Errorf("TimeoutSeconds = %d, want 900", got)
+	}
+
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{})
+	for _, k := range []string{"autoscaling.knative.dev/minScale", "run.googleapis.com/startup-cpu-boost"} {
+		if v, ok := svc.Spec.Template.Metadata.Annotations[k]; ok {
+			t.Errorf("unexpected annotation %s = %q", k, v)
+		}
+	}
+}
+
+func Test_newService_network(t *testing.T) {
+	svc := newService("svc", "project", "image", nil, nil, envSync{}, options{
+		Ingress:     "internal-and-cloud-load-balancing",
+		Network:     "default",
+		Subnet:      "sub",
+		NetworkTags: []string{"a", "b"},
+		VPCEgress:   "private-ranges-only",
+	})
+	if got := svc.Metadata.Annotations["run.googleapis.com/ingress"]; got != "internal-and-cloud-load-balancing" {
+		t.Errorf("wrong ingress annotation %q", got)
+	}
+	annotations := svc.Spec.Template.Metadata.Annotations
+	for k, v := range map[string]string{
+		"run.googleapis.com/network-interfaces": `[{"network":"default","subnetwork":"sub","tags":["a","b"]}]`,
+		"run.googleapis.com/vpc-access-egress":  "private-ranges-only",
+	} {
+		if annotations[k] != v {
+			t.Errorf("annotation %s = %q, want %q", k, annotations[k], v)
+		}
+	}
+
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{VPCConnector: "connector"})
+	annotations = svc.Spec.Template.Metadata.Annotations
+	if got := annotations["run.googleapis.com/vpc-access-connector"]; got != "connector" {
+		t.Errorf("wrong vpc-access-connector annotation %q", got)
+	}
+	if got, ok := annotations["run.googleapis.com/network-interfaces"]; ok {
+		t.Errorf("network-interfaces annotation %q not removed", got)
+	}
+	if got := annotations["run.googleapis.com/vpc-access-egress"]; got != "private-ranges-only" {
+		t.Errorf("vpc-access-egress annotation %q not kept", got)
+	}
+}
+
+func Test_deploy_serviceAccount(t *testing.T) {
+	apply := withServiceAccount("sa@proj.iam.gserviceaccount.com")
+	svc := newService("svc", "project", "image", nil, nil, envSync{}, options{}, apply)
+	if got := svc.Spec.Template.Spec.ServiceAccountName; got != "sa@proj.iam.gserviceaccount.com" {
+		t.Fatalf("newService() ServiceAccountName = %q", got)
+	}
+
+	svc.Spec.Template.Spec.ServiceAccountName = "other@proj.iam.gserviceaccount.com"
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{}, apply)
+	if got := svc.Spec.Template.Spec.ServiceAccountName; got != "sa@proj.iam.gserviceaccount.com" {
+		t.Fatalf("patchService() ServiceAccountName = %q", got)
+	}
+}
+
+func Test_deploy_command(t *testing.T) {
+	o := options{Command: []string{"gunicorn"}, Args: []string{"app:app"}, WorkingDir: "/srv"}
+	svc := newService("svc", "project", "image", nil, nil, envSync{}, o)
+	c := svc.Spec.Template.Spec.Containers[0]
+	if !reflect.DeepEqual(c.Command, o.Command) || !reflect.DeepEqual(c.Args, o.Args) || c.WorkingDir != "/srv" {
+		t.Fatalf("newService() command = %v, args = %v, working dir = %q", c.Command, c.Args, c.WorkingDir)
+	}
+
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{Args: []string{"--reload", "app:app"}})
+	c = svc.Spec.Template.Spec.Containers[0]
+	if c.Command != nil || !reflect.DeepEqual(c.Args, []string{"--reload", "app:app"}) || c.WorkingDir != "" {
+		t.Fatalf("patchService() command = %v, args = %v, working dir = %q", c.Command, c.Args, c.WorkingDir)
+	}
+
+	// redeploying without the options restores the entrypoint of the image
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{})
+	c = svc.Spec.Template.Spec.Containers[0]
+	if c.Command != nil || c.Args != nil || c.WorkingDir != "" {
+		t.Fatalf("patchService() command = %v, args = %v, working dir = %q", c.Command, c.Args, c.WorkingDir)
+	}
+}
+
+func sortEnvVars(envs []*runapi.EnvVar) {
+	sort.Slice(envs, func(i, j int) bool { return envs[i].Name < envs[j].Name })
+}
+
+func envVarsString(envs []*runapi.EnvVar) string {
+	var out string
+	for _, e := range envs {
+		b, _ := e.MarshalJSON()
+		out += string(b) + " "
+	}
+	return out
+}
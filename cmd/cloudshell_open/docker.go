@@ -19,10 +19,109 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
-func dockerBuild(dir, image string) error {
-	cmd := exec.Command("docker", "build", "--quiet", "--tag", image, dir)
+// shellSafePattern matches the arguments that need no quotes in a shell.
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+
+// dockerConfig configures how the Dockerfile of the application is built.
+type dockerConfig struct {
+	// Dockerfile is the path of the Dockerfile, relative to the application
+	// directory.
+	Dockerfile string `json:"dockerfile"`
+	// Context is the build context, relative to the application directory.
+	Context string `json:"context"`
+	Target  string `json:"target"`
+	// Args are the build args. Their values can refer to env vars as ${VAR}.
+	Args     map[string]string `json:"args"`
+	Platform string            `json:"platform"`
+}
+
+// isSet checks if any of the settings is set.
+func (c dockerConfig) isSet() bool {
+	return c.Dockerfile != "" || c.Context != "" || c.Target != "" || len(c.Args) > 0 || c.Platform != ""
+}
+
+func (c dockerConfig) validate() error {
+	if p := c.Dockerfile; p != "" && (filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p), "..")) {
+		return fmt.Errorf("dockerfile must be relative to the application directory")
+	}
+	if p := c.Context; p != "" && (filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p), "..")) {
+		return fmt.Errorf("context must be relative to the application directory")
+	}
+	for k := range c.Args {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			return fmt.Errorf("invalid build arg name %q", k)
+		}
+	}
+	return nil
+}
+
+// argNames returns the names of the build args, sorted.
+func (c dockerConfig) argNames() []string {
+	var out []string
+	for k := range c.Args {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// expand returns the settings with the variables the build args refer to
+// replaced by the values returned by lookup.
+func (c dockerConfig) expand(lookup func(string) (string, bool)) (dockerConfig, error) {
+	if len(c.Args) == 0 {
+		return c, nil
+	}
+	args := make(map[string]string, len(c.Args))
+	for k, v := range c.Args {
+		var err error
+		if args[k], err = expandTemplate(v, lookup); err != nil {
+			return c, fmt.Errorf("build arg %q: %w", k, err)
+		}
+	}
+	c.Args = args
+	return c, nil
+}
+
+// dockerfile returns the path of the Dockerfile of the application in dir.
+func (c dockerConfig) dockerfile(dir string) string {
+	if c.Dockerfile == "" {
+		return filepath.Join(dir, "Dockerfile")
+	}
+	return filepath.Join(dir, c.Dockerfile)
+}
+
+// buildFlags returns the flags of "docker build" for the application in dir,
+// followed by the build context.
+func (c dockerConfig) buildFlags(dir string) []string {
+	var out []string
+	if c.Dockerfile != "" {
+		out = append(out, "--file", c.dockerfile(dir))
+	}
+	if c.Target != "" {
+		out = append(out, "--target", c.Target)
+	}
+	for _, k := range c.argNames() {
+		out = append(out, "--build-arg", k+"="+c.Args[k])
+	}
+	if c.Platform != "" {
+		out = append(out, "--platform", c.Platform)
+	}
+	return append(out, filepath.Join(dir, c.Context))
+}
+
+// dockerBuildArgs returns the arguments of the docker command building the
+// application in dir.
+func dockerBuildArgs(dir, image string, config dockerConfig) []string {
+	return append([]string{"build", "--quiet", "--tag", image}, config.buildFlags(dir)...)
+}
+
+func dockerBuild(dir, image string, config dockerConfig) error {
+	cmd := exec.Command("docker", dockerBuildArgs(dir, image, config)...)
 	b, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("docker build failed: %v, output:\n%s", err, string(b))
@@ -39,8 +138,17 @@ func dockerPush(image string) error {
 	return nil
 }
 
-func dockerFileExists(dir string) (bool, error) {
-	if _, err := os.Stat(filepath.Join(dir, "Dockerfile")); err != nil {
+// shellQuote quotes the argument for a shell, if it needs it.
+func shellQuote(s string) string {
+	if s != "" && shellSafePattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// dockerFileExists checks if there is a Dockerfile at the path.
+func dockerFileExists(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
@@ -49,3 +157,41 @@ func dockerFileExists(dir string) (bool, error) {
 
 	return true, nil
 }
+
+// dockerBuilder builds the Dockerfile of the source with docker.
+type dockerBuilder struct {
+	docker dockerConfig
+}
+
+func (b dockerBuilder) Detect(dir string) (bool, error) {
+	return dockerFileExists(b.docker.dockerfile(dir))
+}
+
+func (b dockerBuilder) Check(dir string) error {
+	if ok, err := b.Detect(dir); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("there is no Dockerfile at %s", b.docker.dockerfile(dir))
+	}
+	if !dockerAvailable() {
+		return fmt.Errorf("docker is not available, use strategy %q to build on Cloud Build", strategyRemote)
+	}
+	return nil
+}
+
+// Describe shows the exact command run by Build, so the paths joined by
+// buildFlags use the unformatted request.
+func (b dockerBuilder) Describe(req buildRequest) (string, string) {
+	req = req.unformatted()
+	var args []string
+	for _, a := range dockerBuildArgs(req.dir, req.image, b.docker) {
+		args = append(args, shellQuote(a))
+	}
+	return "build this application with its Dockerfile", "docker " + strings.Join(args, " ")
+}
+
+func (b dockerBuilder) Build(req buildRequest) (string, error) {
+	return "", dockerBuild(req.dir, req.image, b.docker)
+}
+
+func (dockerBuilder) NeedsPush() bool { return true }
@@ -0,0 +1,281 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var (
+	dotEnvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// values made of these characters are written without quotes
+	dotEnvBareValuePattern = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+)
+
+// readDotEnv reads the values of a .env file.
+func readDotEnv(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	out, err := parseDotEnv(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse env file %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// parseDotEnv parses the KEY=VALUE lines of a .env file. Lines may start with
+// "export", blank lines and lines starting with # are ignored, and so is the
+// rest of a line after a # that follows a space. Values in single quotes are
+// taken literally, values in double quotes support the \n, \r, \t, \", \\ and
+// \$ escapes, and both may span several lines.
+func parseDotEnv(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	line := 1
+	for len(s) > 0 {
+		var l string
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			l, s = s[:i], s[i+1:]
+		} else {
+			l, s = s, ""
+		}
+		start := line
+		line++
+
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		l = strings.TrimPrefix(l, "export ")
+		eq := strings.IndexByte(l, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: missing '='", start)
+		}
+		key := strings.TrimSpace(l[:eq])
+		if !dotEnvKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", start, key)
+		}
+		value := strings.TrimLeft(l[eq+1:], " \t")
+
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			} else if i := strings.Index(value, "\t#"); i >= 0 {
+				value = value[:i]
+			}
+			out[key] = strings.TrimSpace(value)
+			continue
+		}
+
+		// quoted values may continue on the next lines
+		quote := value[0]
+		rest := value[1:]
+		var b strings.Builder
+		for {
+			end := closingQuote(rest, quote)
+			if end >= 0 {
+				b.WriteString(rest[:end])
+				rest = strings.TrimSpace(rest[end+1:])
+				break
+			}
+			if len(s) == 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value of %s", start, key)
+			}
+			b.WriteString(rest)
+			b.WriteByte('\n')
+			if i := strings.IndexByte(s, '\n'); i >= 0 {
+				rest, s = s[:i], s[i+1:]
+			} else {
+				rest, s = s, ""
+			}
+			line++
+		}
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after the quoted value of %s", line-1, rest, key)
+		}
+		value = b.String()
+		if quote == '"' {
+			value = unescapeDotEnv(value)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// closingQuote returns the index of the quote ending the value in s, or -1 if
+// s doesn't contain it. Escaped double quotes don't end a value.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotEnv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			// unknown escapes are kept as is
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// formatDotEnv returns the values in the .env format, sorted by name, quoting
+// the values that need it so that parseDotEnv reads them back.
+func formatDotEnv(values map[string]string) string {
+	var b strings.Builder
+	for _, k := range sortedKeys(values) {
+		b.WriteString(k + "=" + quoteDotEnv(values[k]) + "\n")
+	}
+	return b.String()
+}
+
+func quoteDotEnv(v string) string {
+	if dotEnvBareValuePattern.MatchString(v) {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// writeDotEnv writes the values to a .env file only readable by the user, as
+// it may contain secrets.
+func writeDotEnv(path string, values map[string]string) error {
+	content := "# Environment variables of the application deployed with the Cloud Run Button\n" + formatDotEnv(values)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	return nil
+}
+
+// savedEnvs collects the values of the env vars of the application to save
+// them in a .env file.
+type savedEnvs struct {
+	values map[string]string
+	// generatedSecrets are the keys of the values that are generated secrets,
+	// which are only saved if the user asks for it
+	generatedSecrets map[string]bool
+	// prefix is added to the keys of the values, see dotEnvPrefix
+	prefix string
+}
+
+func newSavedEnvs() *savedEnvs {
+	return &savedEnvs{values: make(map[string]string), generatedSecrets: make(map[string]bool)}
+}
+
+// scoped returns the saved env vars recording the values with the prefix.
+func (s *savedEnvs) scoped(prefix string) *savedEnvs {
+	return &savedEnvs{values: s.values, generatedSecrets: s.generatedSecrets, prefix: prefix}
+}
+
+// dotEnvPrefix returns the prefix of the env vars of the service in the .env
+// file of an app.json with "services", e.g. "MY_API__" for service "my-api",
+// so that services can declare the same variables.
+func dotEnvPrefix(service string) string {
+	return serviceEnvName(service) + "__"
+}
+
+// validateDotEnvKeys checks that the env vars of the services get distinct
+// keys in the .env file once prefixed, e.g. "A__B__C" is both "B__C" of
+// service "a" and "C" of service "a--b".
+func validateDotEnvKeys(list []service) error {
+	keys := make(map[string]string)
+	for _, s := range list {
+		prefix := dotEnvPrefix(s.Name)
+		for k := range s.Env {
+			key := prefix + k
+			if other, ok := keys[key]; ok {
+				return fmt.Errorf("env vars of services %q and %q would both be saved as %s in env files", other, s.Name, key)
+			}
+			keys[key] = s.Name
+		}
+	}
+	return nil
+}
+
+// scopedAnswers returns the values of the env file whose key has the prefix,
+// keyed without it.
+func scopedAnswers(answers map[string]string, prefix string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range answers {
+		if name := strings.TrimPrefix(k, prefix); name != k && name != "" {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// add records the K=V pairs of the env vars of list. answered holds the values
+// that were read from an env file rather than generated.
+func (s *savedEnvs) add(envs []string, list map[string]env, answered map[string]string) {
+	for k, v := range parseEnv(envs) {
+		e, ok := list[k]
+		if !ok {
+			continue
+		}
+		s.values[s.prefix+k] = v
+		_, isAnswered := answered[k]
+		s.generatedSecrets[s.prefix+k] = !isAnswered && e.generatesSecret()
+	}
+}
+
+// toSave returns the values to save in the .env file.
+func (s *savedEnvs) toSave(withSecrets bool) map[string]string {
+	out := make(map[string]string)
+	for k, v := range s.values {
+		if withSecrets || !s.generatedSecrets[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// numSecrets returns the number of generated secret values.
+func (s *savedEnvs) numSecrets() int {
+	n := 0
+	for _, secret := range s.generatedSecrets {
+		if secret {
+			n++
+		}
+	}
+	return n
+}
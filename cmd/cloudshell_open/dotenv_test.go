@@ -0,0 +1,232 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_parseDotEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"comments and blank lines", "# comment\n\n  # indented comment\nA=1\n", map[string]string{"A": "1"}, false},
+		{"bare values", "A=1\nB = two words \nC=\nD=x=y", map[string]string{"A": "1", "B": "two words", "C": "", "D": "x=y"}, false},
+		{"export", "export A=1", map[string]string{"A": "1"}, false},
+		{"inline comment", "A=1 # comment\nB=a#b", map[string]string{"A": "1", "B": "a#b"}, false},
+		{"crlf", "A=1\r\nB=2\r\n", map[string]string{"A": "1", "B": "2"}, false},
+		{"single quotes are literal", `A='a\nb # c'`, map[string]string{"A": `a\nb # c`}, false},
+		{"double quote escapes", `A="a\nb\t\"c\" \\ \$d \x"`, map[string]string{"A": "a\nb\t\"c\" \\ $d \\x"}, false},
+		{"comment after quotes", `A="a" # comment`, map[string]string{"A": "a"}, false},
+		{"multi-line double quotes", "A=\"line 1\nline 2\"\nB=2", map[string]string{"A": "line 1\nline 2", "B": "2"}, false},
+		{"multi-line single quotes", "A='-----BEGIN KEY-----\nabc\n-----END KEY-----'", map[string]string{"A": "-----BEGIN KEY-----\nabc\n-----END KEY-----"}, false},
+		{"last value wins", "A=1\nA=2", map[string]string{"A": "2"}, false},
+		{"missing =", "A", nil, true},
+		{"invalid name", "1A=1", nil, true},
+		{"unterminated quotes", "A=\"abc\nB=1", nil, true},
+		{"text after quotes", `A="a"b`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotEnv(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDotEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseDotEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatDotEnv(t *testing.T) {
+	values := map[string]string{
+		"BARE":      "postgres://user@host:5432/db",
+		"EMPTY":     "",
+		"SPACES":    "two words",
+		"MULTILINE": "line 1\nline 2\r\n",
+		"QUOTES":    `say "hi" \o/ # not a comment`,
+		"DOLLAR":    "$HOME",
+	}
+	got := formatDotEnv(values)
+	expected := "BARE=postgres://user@host:5432/db\n" +
+		"DOLLAR=\"$HOME\"\n" +
+		"EMPTY=\n" +
+		"MULTILINE=\"line 1\\nline 2\\r\\n\"\n" +
+		"QUOTES=\"say \\\"hi\\\" \\\\o/ # not a comment\"\n" +
+		"SPACES=\"two words\"\n"
+	if got != expected {
+		t.Fatalf("formatDotEnv() = %q, want %q", got, expected)
+	}
+
+	parsed, err := parseDotEnv(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, values) {
+		t.Fatalf("parseDotEnv(formatDotEnv()) = %q, want %q", parsed, values)
+	}
+}
+
+func Test_writeDotEnv(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "dotenv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".env")
+	values := map[string]string{"A": "1", "B": "x y"}
+	if err := writeDotEnv(path, values); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("wrong permissions %v", fi.Mode().Perm())
+	}
+	got, err := readDotEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Fatalf("readDotEnv() = %v, want %v", got, values)
+	}
+}
+
+func Test_savedEnvs(t *testing.T) {
+	list := map[string]env{
+		"PLAIN":     {},
+		"TOKEN":     {Generator: generatorSpec{Type: "secret"}},
+		"DB_PASS":   {Generator: generatorSpec{Type: "alnum"}, Secret: true},
+		"ID":        {Generator: generatorSpec{Type: "uuid"}},
+		"API_TOKEN": {Generator: generatorSpec{Type: "password"}},
+	}
+	saved := newSavedEnvs()
+	saved.add([]string{"PLAIN=p", "TOKEN=t", "DB_PASS=d", "ID=i", "API_TOKEN=a", "EXTRA=x"}, list,
+		map[string]string{"API_TOKEN": "a"})
+
+	if got := saved.numSecrets(); got != 2 {
+		t.Fatalf("numSecrets() = %d, want 2", got)
+	}
+	if got, expected := saved.toSave(false), map[string]string{"PLAIN": "p", "ID": "i", "API_TOKEN": "a"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("toSave(false) = %v, want %v", got, expected)
+	}
+	if got, expected := saved.toSave(true), map[string]string{"PLAIN": "p", "TOKEN": "t", "DB_PASS": "d", "ID": "i", "API_TOKEN": "a"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("toSave(true) = %v, want %v", got, expected)
+	}
+}
+
+func Test_savedEnvs_scoped(t *testing.T) {
+	list := map[string]env{"DB_PASSWORD": {}}
+	saved := newSavedEnvs()
+	saved.scoped(dotEnvPrefix("api")).add([]string{"DB_PASSWORD=a"}, list, nil)
+	saved.scoped(dotEnvPrefix("my-worker")).add([]string{"DB_PASSWORD=w"}, list, nil)
+	expected := map[string]string{"API__DB_PASSWORD": "a", "MY_WORKER__DB_PASSWORD": "w"}
+	if got := saved.toSave(true); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("toSave() = %v, want %v", got, expected)
+	}
+
+	answers := map[string]string{"API__DB_PASSWORD": "a", "MY_WORKER__DB_PASSWORD": "w", "DB_PASSWORD": "shared", "API__": "x"}
+	if got := scopedAnswers(answers, dotEnvPrefix("api")); !reflect.DeepEqual(got, map[string]string{"DB_PASSWORD": "a"}) {
+		t.Fatalf("scopedAnswers() = %v", got)
+	}
+}
+
+func Test_dotEnvPrefix(t *testing.T) {
+	for name, want := range map[string]string{
+		"api":    "API__",
+		"my-api": "MY_API__",
+		"1api":   "SVC_1API__",
+	} {
+		got := dotEnvPrefix(name)
+		if got != want {
+			t.Errorf("dotEnvPrefix(%q) = %s, want %s", name, got, want)
+		}
+		if !dotEnvKeyPattern.MatchString(got + "KEY") {
+			t.Errorf("dotEnvPrefix(%q) = %s doesn't make valid keys", name, got)
+		}
+	}
+}
+
+func Test_validateDotEnvKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []service
+		wantErr bool
+	}{
+		{"same env in several services",
+			[]service{{Name: "api", Env: map[string]env{"KEY": {}}}, {Name: "worker", Env: map[string]env{"KEY": {}}}},
+			false},
+		{"prefixed keys collide",
+			[]service{{Name: "a", Env: map[string]env{"B__C": {}}}, {Name: "a--b", Env: map[string]env{"C": {}}}},
+			true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateDotEnvKeys(tt.in); (err != nil) != tt.wantErr {
+				t.Fatalf("validateDotEnvKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_dotEnv_servicesRoundTrip(t *testing.T) {
+	values := map[string]map[string]string{
+		"api":       {"DB_PASSWORD": "a", "GREETING": "hello world"},
+		"my-worker": {"DB_PASSWORD": "w\nx", "QUEUE": "jobs"},
+		"1cron":     {"DB_PASSWORD": "it's \"quoted\""},
+	}
+	saved := newSavedEnvs()
+	for name, envs := range values {
+		list := make(map[string]env)
+		var kvs []string
+		for k, v := range envs {
+			list[k] = env{}
+			kvs = append(kvs, k+"="+v)
+		}
+		saved.scoped(dotEnvPrefix(name)).add(kvs, list, nil)
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "dotenv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".env")
+	if err := writeDotEnv(path, saved.toSave(true)); err != nil {
+		t.Fatal(err)
+	}
+	answers, err := readDotEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, envs := range values {
+		if got := scopedAnswers(answers, dotEnvPrefix(name)); !reflect.DeepEqual(got, envs) {
+			t.Errorf("scopedAnswers(%s) = %v, want %v", name, got, envs)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generatorSpec configures the generator of an env var value. In app.json it is
+// either the name of a generator, or an object with the generator "type" and
+// its parameters.
+type generatorSpec struct {
+	Type     string   `json:"type"`
+	Length   int      `json:"length"`
+	Charsets []string `json:"charsets"`
+	Min      int      `json:"min"`
+	Max      int      `json:"max"`
+	Template string   `json:"template"`
+}
+
+func (g *generatorSpec) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*g = generatorSpec{Type: name}
+		return nil
+	}
+
+	// alias drops the UnmarshalJSON method to decode the object form
+	type alias generatorSpec
+	var v alias
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("generator must be a name or an object: %w", err)
+	}
+	*g = generatorSpec(v)
+	return nil
+}
+
+// envGenerator generates values of env vars.
+type envGenerator struct {
+	// validate checks the parameters of the generator.
+	validate func(g generatorSpec) error
+	// generate returns a new value, vars holds the values of the other env vars.
+	generate func(g generatorSpec, vars map[string]string) (string, error)
+}
+
+const (
+	defaultGeneratedLength = 32
+	maxGeneratedLength     = 4096
+
+	alphaLowerChars = "abcdefghijklmnopqrstuvwxyz"
+	alphaUpperChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars      = "0123456789"
+	symbolChars     = "!#%*+,-.:=?@^_~"
+)
+
+var (
+	generators = map[string]envGenerator{
+		"secret": {
+			validate: noParams,
+			generate: func(generatorSpec, map[string]string) (string, error) { return rand64String() },
+		},
+		"uuid": {
+			validate: noParams,
+			generate: func(generatorSpec, map[string]string) (string, error) { return randUUID() },
+		},
+		"hex": {
+			validate: validateLength,
+			generate: func(g generatorSpec, _ map[string]string) (string, error) { return randHex(generatedLength(g)) },
+		},
+		"alnum": {
+			validate: validateLength,
+			generate: func(g generatorSpec, _ map[string]string) (string, error) {
+				return randString(generatedLength(g), alphaLowerChars+alphaUpperChars+digitChars)
+			},
+		},
+		"password": {
+			validate: validatePassword,
+			generate: func(g generatorSpec, _ map[string]string) (string, error) { return randPassword(g) },
+		},
+		"port": {
+			validate: validatePortRange,
+			generate: func(g generatorSpec, _ map[string]string) (string, error) { return randFreePort(g) },
+		},
+		"template": {
+			validate: func(g generatorSpec) error {
+				if err := checkParams(g, "template"); err != nil {
+					return err
+				}
+				if g.Template == "" {
+					return errors.New(`"template" must be specified`)
+				}
+				return nil
+			},
+			generate: func(g generatorSpec, vars map[string]string) (string, error) {
+				return expandTemplate(g.Template, func(k string) (string, bool) {
+					v, ok := vars[k]
+					return v, ok
+				})
+			},
+		},
+	}
+
+	passwordCharsets = map[string]string{
+		"lower":   alphaLowerChars,
+		"upper":   alphaUpperChars,
+		"digits":  digitChars,
+		"symbols": symbolChars,
+	}
+
+	// templateRefPattern also matches the escaped references, $${VAR}
+	templateRefPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// validate checks that the generator exists and that its parameters are valid.
+func (g generatorSpec) validate() error {
+	gen, ok := generators[g.Type]
+	if !ok {
+		var names []string
+		for k := range generators {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown generator %q, must be one of: %s", g.Type, strings.Join(names, ", "))
+	}
+	if err := gen.validate(g); err != nil {
+		return fmt.Errorf("invalid parameters for generator %q: %w", g.Type, err)
+	}
+	return nil
+}
+
+// generate returns a new value from the generator.
+func (g generatorSpec) generate(vars map[string]string) (string, error) {
+	gen, ok := generators[g.Type]
+	if !ok {
+		return "", fmt.Errorf("unknown generator %q", g.Type)
+	}
+	return gen.generate(g, vars)
+}
+
+func noParams(g generatorSpec) error {
+	return checkParams(g)
+}
+
+// checkParams fails if parameters other than the allowed ones are set.
+func checkParams(g generatorSpec, allowed ...string) error {
+	params := []struct {
+		name string
+		set  bool
+	}{
+		{"length", g.Length != 0},
+		{"charsets", len(g.Charsets) > 0},
+		{"min", g.Min != 0},
+		{"max", g.Max != 0},
+		{"template", g.Template != ""},
+	}
+	for _, p := range params {
+		if !p.set {
+			continue
+		}
+		ok := false
+		for _, a := range allowed {
+			ok = ok || a == p.name
+		}
+		if !ok {
+			return fmt.Errorf("unsupported parameter %q", p.name)
+		}
+	}
+	return nil
+}
+
+func validateLength(g generatorSpec) error {
+	if err := checkParams(g, "length"); err != nil {
+		return err
+	}
+	return checkLength(g)
+}
+
+func checkLength(g generatorSpec) error {
+	if g.Length < 0 || g.Length > maxGeneratedLength {
+		return fmt.Errorf(`"length" must be between 1 and %d`, maxGeneratedLength)
+	}
+	return nil
+}
+
+func validatePassword(g generatorSpec) error {
+	if err := checkParams(g, "length", "charsets"); err != nil {
+		return err
+	}
+	if err := checkLength(g); err != nil {
+		return err
+	}
+	for _, c := range g.Charsets {
+		if _, ok := passwordCharsets[c]; !ok {
+			return fmt.Errorf("unknown charset %q, must be one of: lower, upper, digits, symbols", c)
+		}
+	}
+	if len(g.Charsets) > generatedLength(g) {
+		return errors.New(`"length" is too short to include all of the "charsets"`)
+	}
+	return nil
+}
+
+func validatePortRange(g generatorSpec) error {
+	if err := checkParams(g, "min", "max"); err != nil {
+		return err
+	}
+	min, max := portRange(g)
+	if min < 1 || max > 65535 || min > max {
+		return errors.New(`"min" and "max" must be a port range within 1-65535`)
+	}
+	return nil
+}
+
+func generatedLength(g generatorSpec) int {
+	if g.Length > 0 {
+		return g.Length
+	}
+	return defaultGeneratedLength
+}
+
+func portRange(g generatorSpec) (int, int) {
+	min, max := 1024, 65535
+	if g.Min > 0 {
+		min = g.Min
+	}
+	if g.Max > 0 {
+		max = g.Max
+	}
+	return min, max
+}
+
+func randInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+func randString(length int, chars string) (string, error) {
+	b := make([]byte, length)
+	for i := range b {
+		n, err := randInt(len(chars))
+		if err != nil {
+			return "", err
+		}
+		b[i] = chars[n]
+	}
+	return string(b), nil
+}
+
+func randHex(length int) (string, error) {
+	b := make([]byte, (length+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b)[:length], nil
+}
+
+func randUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// randPassword generates a password with at least one character from each of
+// the charsets of the generator.
+func randPassword(g generatorSpec) (string, error) {
+	charsets := g.Charsets
+	if len(charsets) == 0 {
+		charsets = []string{"lower", "upper", "digits", "symbols"}
+	}
+	var all string
+	for _, c := range charsets {
+		all += passwordCharsets[c]
+	}
+
+	b := []byte{}
+	for _, c := range charsets {
+		s, err := randString(1, passwordCharsets[c])
+		if err != nil {
+			return "", err
+		}
+		b = append(b, s...)
+	}
+	s, err := randString(generatedLength(g)-len(b), all)
+	if err != nil {
+		return "", err
+	}
+	b = append(b, s...)
+
+	// shuffle so that the required characters aren't always first
+	for i := len(b) - 1; i > 0; i-- {
+		j, err := randInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b), nil
+}
+
+// randFreePort picks a random port in the range of the generator that is not
+// in use on this machine.
+func randFreePort(g generatorSpec) (string, error) {
+	min, max := portRange(g)
+	for i := 0; i < 100; i++ {
+		n, err := randInt(max - min + 1)
+		if err != nil {
+			return "", err
+		}
+		port := strconv.Itoa(min + n)
+		l, err := net.Listen("tcp", net.JoinHostPort("", port))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		return port, nil
+	}
+	return "", fmt.Errorf("could not find a free port between %d and %d", min, max)
+}
+
+// templateRefs returns the names of the variables the template refers to.
+func templateRefs(tmpl string) []string {
+	var out []string
+	for _, m := range templateRefPattern.FindAllStringSubmatch(tmpl, -1) {
+		if !strings.HasPrefix(m[0], "$$") {
+			out = append(out, m[1])
+		}
+	}
+	return out
+}
+
+// expandTemplate replaces the ${VAR} references in the template with the
+// values returned by lookup, and fails if a referenced variable has no value.
+// Escaped references, $${VAR}, are replaced with a literal ${VAR}.
+func expandTemplate(tmpl string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	out := templateRefPattern.ReplaceAllStringFunc(tmpl, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		k := templateRefPattern.FindStringSubmatch(ref)[1]
+		v, ok := lookup(k)
+		if !ok {
+			missing = append(missing, k)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
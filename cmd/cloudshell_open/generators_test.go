@@ -0,0 +1,182 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_generatorSpec_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    generatorSpec
+		wantErr bool
+	}{
+		{`"secret"`, generatorSpec{Type: "secret"}, false},
+		{`{"type": "alnum", "length": 10}`, generatorSpec{Type: "alnum", Length: 10}, false},
+		{`{"type": "password", "charsets": ["lower", "digits"]}`,
+			generatorSpec{Type: "password", Charsets: []string{"lower", "digits"}}, false},
+		{`{"type": "alnum", "unknown": 1}`, generatorSpec{}, true},
+		{`1`, generatorSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var got generatorSpec
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal(%s) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generatorSpec_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      generatorSpec
+		wantErr bool
+	}{
+		{"secret", generatorSpec{Type: "secret"}, false},
+		{"unknown", generatorSpec{Type: "foo"}, true},
+		{"secret takes no length", generatorSpec{Type: "secret", Length: 1}, true},
+		{"hex length", generatorSpec{Type: "hex", Length: 8}, false},
+		{"negative length", generatorSpec{Type: "alnum", Length: -1}, true},
+		{"too long", generatorSpec{Type: "alnum", Length: maxGeneratedLength + 1}, true},
+		{"alnum takes no charsets", generatorSpec{Type: "alnum", Charsets: []string{"lower"}}, true},
+		{"password charsets", generatorSpec{Type: "password", Charsets: []string{"lower", "symbols"}}, false},
+		{"password unknown charset", generatorSpec{Type: "password", Charsets: []string{"emoji"}}, true},
+		{"password too short for charsets", generatorSpec{Type: "password", Length: 1, Charsets: []string{"lower", "upper"}}, true},
+		{"port range", generatorSpec{Type: "port", Min: 8000, Max: 9000}, false},
+		{"port range out of bounds", generatorSpec{Type: "port", Max: 70000}, true},
+		{"port range reversed", generatorSpec{Type: "port", Min: 9000, Max: 8000}, true},
+		{"template", generatorSpec{Type: "template", Template: "${A}"}, false},
+		{"empty template", generatorSpec{Type: "template"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.in.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_generatorSpec_generate(t *testing.T) {
+	tests := []struct {
+		in      generatorSpec
+		pattern string
+	}{
+		{generatorSpec{Type: "secret"}, `^[A-Za-z0-9+/]{86}==$`},
+		{generatorSpec{Type: "uuid"}, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{generatorSpec{Type: "hex", Length: 7}, `^[0-9a-f]{7}$`},
+		{generatorSpec{Type: "alnum"}, `^[A-Za-z0-9]{32}$`},
+		{generatorSpec{Type: "password", Length: 12}, `^[A-Za-z0-9!#%*+,\-.:=?@^_~]{12}$`},
+		{generatorSpec{Type: "password", Length: 8, Charsets: []string{"digits"}}, `^[0-9]{8}$`},
+		{generatorSpec{Type: "template", Template: "${A}-${B}"}, `^a-b$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in.Type, func(t *testing.T) {
+			got, err := tt.in.generate(map[string]string{"A": "a", "B": "b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !regexp.MustCompile(tt.pattern).MatchString(got) {
+				t.Errorf("generate() = %q, doesn't match %s", got, tt.pattern)
+			}
+		})
+	}
+}
+
+func Test_randPassword_charsets(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		got, err := randPassword(generatorSpec{Type: "password", Length: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chars := range []string{alphaLowerChars, alphaUpperChars, digitChars, symbolChars} {
+			if !strings.ContainsAny(got, chars) {
+				t.Fatalf("password %q has no character from %q", got, chars)
+			}
+		}
+	}
+}
+
+func Test_randFreePort(t *testing.T) {
+	got, err := randFreePort(generatorSpec{Type: "port", Min: 20000, Max: 20100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := strconv.Atoi(got); err != nil || p < 20000 || p > 20100 {
+		t.Fatalf("randFreePort() = %s, not in range", got)
+	}
+}
+
+func Test_expandTemplate(t *testing.T) {
+	vars := map[string]string{"A": "1", "B": "2"}
+	lookup := func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+
+	got, err := expandTemplate("${A}:${B}/$A/${A", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1:2/$A/${A"; got != expected {
+		t.Fatalf("expandTemplate() = %q, want %q", got, expected)
+	}
+
+	if _, err := expandTemplate("${A}${C}", lookup); err == nil {
+		t.Fatal("expected error for missing variable")
+	}
+
+	if refs := templateRefs("${A}${C}x"); !reflect.DeepEqual(refs, []string{"A", "C"}) {
+		t.Fatalf("templateRefs() = %v", refs)
+	}
+
+	got, err = expandTemplate("$${HOME}/data/${A}", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "${HOME}/data/1"; got != expected {
+		t.Fatalf("expandTemplate() = %q, want %q", got, expected)
+	}
+	if refs := templateRefs("$${HOME}/${A}"); !reflect.DeepEqual(refs, []string{"A"}) {
+		t.Fatalf("templateRefs() = %v", refs)
+	}
+}
+
+func Test_generateEnvs(t *testing.T) {
+	got, err := generateEnvs(map[string]env{
+		"URL":  {Generator: generatorSpec{Type: "template", Template: "${HOST}:${PORT}"}},
+		"HOST": {Generator: generatorSpec{Type: "template", Template: "${USER}@localhost"}},
+		"PORT": {Generator: generatorSpec{Type: "port", Min: 20000, Max: 20100}},
+	}, map[string]string{"USER": "me"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := parseEnv(got)
+	if expected := "me@localhost:" + values["PORT"]; values["URL"] != expected {
+		t.Fatalf("URL = %q, want %q", values["URL"], expected)
+	}
+}
@@ -33,6 +33,30 @@ func jibMavenBuild(dir string, image string) error {
 }
 
 func jibMavenConfigured(dir string) (bool, error) {
+	declared, err := jibMavenDeclared(dir)
+	if err != nil || !declared {
+		return false, err
+	}
+
+	cmd := createMavenCommand(dir, "--batch-mode",
+		"jib:_skaffold-fail-if-jib-out-of-date", "-Djib.requiredVersion=1.4.0")
+	if err := runJibCheck(cmd); err == nil {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// runJibCheck runs the command checking that the Jib plugin is usable. Tests
+// replace it to not run maven.
+var runJibCheck = func(cmd *exec.Cmd) error {
+	_, err := cmd.CombinedOutput()
+	return err
+}
+
+// jibMavenDeclared checks if the pom.xml of the directory declares the Jib
+// plugin.
+func jibMavenDeclared(dir string) (bool, error) {
 	content, err := ioutil.ReadFile(filepath.Join(dir, "pom.xml"))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -40,25 +64,20 @@ func jibMavenConfigured(dir string) (bool, error) {
 		}
 		return false, fmt.Errorf("failed to check for pom.xml in the repo: %v", err)
 	}
-
-	if strings.Contains(string(content), "<artifactId>jib-maven-plugin</artifactId>") {
-		cmd := createMavenCommand(dir, "--batch-mode",
-			"jib:_skaffold-fail-if-jib-out-of-date", "-Djib.requiredVersion=1.4.0")
-		if _, err := cmd.CombinedOutput(); err == nil {
-			return true, nil
-		}
-	}
-
-	return false, nil
+	return strings.Contains(string(content), "<artifactId>jib-maven-plugin</artifactId>"), nil
 }
 
 func createMavenCommand(dir string, args ...string) *exec.Cmd {
-	executable := "mvn"
+	return createWrapperCommand(dir, "mvnw", "mvn", args...)
+}
 
-	if stat, err := os.Stat(filepath.Join(dir, "mvnw")); err == nil {
+// createWrapperCommand returns the command running the build tool in the
+// directory, using its wrapper script if the directory has one.
+func createWrapperCommand(dir, wrapper, executable string, args ...string) *exec.Cmd {
+	if stat, err := os.Stat(filepath.Join(dir, wrapper)); err == nil {
 		if (stat.Mode() & 0111) != 0 {
-			if wrapper, err := filepath.Abs(filepath.Join(dir, "mvnw")); err == nil {
-				executable = wrapper
+			if path, err := filepath.Abs(filepath.Join(dir, wrapper)); err == nil {
+				executable = path
 			}
 		}
 	}
@@ -67,3 +86,90 @@ func createMavenCommand(dir string, args ...string) *exec.Cmd {
 	cmd.Dir = dir
 	return cmd
 }
+
+// checkBuildTool checks that the build tool or its wrapper script in the
+// directory can be run.
+func checkBuildTool(dir, wrapper, executable string) error {
+	if stat, err := os.Stat(filepath.Join(dir, wrapper)); err == nil && (stat.Mode()&0111) != 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(executable); err != nil {
+		return fmt.Errorf("%s is not installed and there is no %s in %s", executable, wrapper, dir)
+	}
+	return nil
+}
+
+func jibGradleBuild(dir string, image string) error {
+	cmd := createWrapperCommand(dir, "gradlew", "gradle", "--console=plain",
+		"jib", "--image="+image, "-Djib.to.credHelper=gcloud")
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Jib Gradle build failed: %v, output:\n%s", err, string(b))
+	}
+	return nil
+}
+
+// jibGradleDeclared checks if the Gradle build script of the directory
+// declares the Jib plugin.
+func jibGradleDeclared(dir string) (bool, error) {
+	for _, f := range []string{"build.gradle", "build.gradle.kts"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return false, fmt.Errorf("failed to check for %s in the repo: %v", f, err)
+		}
+		if strings.Contains(string(content), "com.google.cloud.tools.jib") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// jibMavenBuilder builds and pushes the image with the Jib Maven plugin.
+type jibMavenBuilder struct{}
+
+func (jibMavenBuilder) Detect(dir string) (bool, error) { return jibMavenConfigured(dir) }
+
+func (jibMavenBuilder) Check(dir string) error {
+	if ok, err := jibMavenDeclared(dir); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("pom.xml in %s doesn't declare the jib-maven-plugin", dir)
+	}
+	return checkBuildTool(dir, "mvnw", "mvn")
+}
+
+func (jibMavenBuilder) Describe(req buildRequest) (string, string) {
+	return "build this application with Jib Maven plugin", fmt.Sprintf("mvn package jib:build -Dimage=%s", req.image)
+}
+
+func (jibMavenBuilder) Build(req buildRequest) (string, error) {
+	return "", jibMavenBuild(req.dir, req.image)
+}
+
+func (jibMavenBuilder) NeedsPush() bool { return false }
+
+// jibGradleBuilder builds and pushes the image with the Jib Gradle plugin.
+type jibGradleBuilder struct{}
+
+func (jibGradleBuilder) Detect(dir string) (bool, error) { return jibGradleDeclared(dir) }
+
+func (jibGradleBuilder) Check(dir string) error {
+	if ok, err := jibGradleDeclared(dir); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("no build.gradle or build.gradle.kts in %s declares the com.google.cloud.tools.jib plugin", dir)
+	}
+	return checkBuildTool(dir, "gradlew", "gradle")
+}
+
+func (jibGradleBuilder) Describe(req buildRequest) (string, string) {
+	return "build this application with Jib Gradle plugin", fmt.Sprintf("gradle jib --image=%s", req.image)
+}
+
+func (jibGradleBuilder) Build(req buildRequest) (string, error) {
+	return "", jibGradleBuild(req.dir, req.image)
+}
+
+func (jibGradleBuilder) NeedsPush() bool { return false }
@@ -0,0 +1,402 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	runapi "google.golang.org/api/run/v1"
+)
+
+const (
+	kindService = "service"
+	kindJob     = "job"
+
+	// maxJobRetries is the maximum number of retries of a failed task.
+	maxJobRetries = 10
+	// maxTaskTimeout is the maximum timeout of a task, in seconds.
+	maxTaskTimeout = 7 * 24 * 3600
+	// defaultTaskTimeout and defaultJobRetries are the Cloud Run defaults.
+	defaultTaskTimeout = 600
+	defaultJobRetries  = 3
+	// executionMargin is the time left for scheduling the tasks of an
+	// execution on top of their timeout.
+	executionMargin = 10 * time.Minute
+)
+
+// job configures a Cloud Run job, deployed instead of a service when the kind
+// is "job".
+type job struct {
+	Tasks       int  `json:"tasks"`
+	Parallelism int  `json:"parallelism"`
+	MaxRetries  *int `json:"max-retries"`
+	// TaskTimeout is in seconds.
+	TaskTimeout int `json:"task-timeout"`
+	// Execute runs the job once it is deployed.
+	Execute bool `json:"execute"`
+}
+
+// isJob checks if the service is deployed as a Cloud Run job.
+func (s service) isJob() bool {
+	return s.Kind == kindJob
+}
+
+// jobSettings returns the job settings of the service.
+func (s service) jobSettings() job {
+	if s.Job == nil {
+		return job{}
+	}
+	return *s.Job
+}
+
+// validateKind checks the kind of the service, and that jobs only use the
+// settings that apply to them.
+func validateKind(s service) error {
+	switch s.Kind {
+	case "", kindService:
+		if s.Job != nil {
+			return fmt.Errorf("job settings require kind %q", kindJob)
+		}
+		return nil
+	case kindJob:
+	default:
+		return fmt.Errorf("unknown kind %q, must be %q or %q", s.Kind, kindService, kindJob)
+	}
+
+	if err := s.jobSettings().validate(); err != nil {
+		return fmt.Errorf("invalid job: %w", err)
+	}
+	o := s.Options
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"allow-unauthenticated", o.AllowUnauthenticated != nil},
+		{"port", o.Port != 0},
+		{"http2", o.HTTP2 != nil},
+		{"concurrency", o.Concurrency != 0},
+		{"max-instances", o.MaxInstances != 0},
+		{"min-instances", o.MinInstances != nil},
+		{"timeout", o.Timeout != 0},
+		{"cpu-boost", o.CPUBoost != nil},
+		{"cpu-throttling", o.CPUThrottling != nil},
+		{"ingress", o.Ingress != ""},
+	} {
+		if opt.set {
+			return fmt.Errorf("option %q doesn't apply to jobs", opt.name)
+		}
+	}
+	if len(s.Sidecars) > 0 {
+		return fmt.Errorf("jobs can't have sidecars")
+	}
+	if s.Probes != nil {
+		return fmt.Errorf("jobs can't have probes")
+	}
+	for _, k := range sortedEnvs(s.Env) {
+		if contains(envDeps(s.Env[k]), serviceURLVar) {
+			return fmt.Errorf("env var %q refers to %s, which jobs don't have", k, serviceURLVar)
+		}
+	}
+	return nil
+}
+
+// validate checks the job settings.
+func (j job) validate() error {
+	if j.Tasks < 0 {
+		return fmt.Errorf("tasks must not be negative")
+	}
+	if j.Parallelism < 0 {
+		return fmt.Errorf("parallelism must not be negative")
+	}
+	if j.Tasks > 0 && j.Parallelism > j.Tasks {
+		return fmt.Errorf("parallelism (%d) must not be greater than tasks (%d)", j.Parallelism, j.Tasks)
+	}
+	if j.MaxRetries != nil && (*j.MaxRetries < 0 || *j.MaxRetries > maxJobRetries) {
+		return fmt.Errorf("max-retries must be between 0 and %d", maxJobRetries)
+	}
+	if j.TaskTimeout < 0 || j.TaskTimeout > maxTaskTimeout {
+		return fmt.Errorf("task-timeout must be between 1 and %d seconds", maxTaskTimeout)
+	}
+	return nil
+}
+
+func getJob(project, name, region string) (*runapi.Job, error) {
+	client, err := runClient(region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Run API client: %w", err)
+	}
+	return client.Namespaces.Jobs.Get(fmt.Sprintf("namespaces/%s/jobs/%s", project, name)).Do()
+}
+
+// jobService returns a Service holding the metadata, containers, volumes and
+// service account of the Job, so that the functions changing Services can
+// change them. applyJobService copies them back to the Job.
+func jobService(j *runapi.Job) *runapi.Service {
+	if j.Spec.Template.Metadata == nil {
+		j.Spec.Template.Metadata = &runapi.ObjectMeta{}
+	}
+	if j.Metadata.Annotations == nil {
+		j.Metadata.Annotations = make(map[string]string)
+	}
+	if j.Spec.Template.Metadata.Annotations == nil {
+		j.Spec.Template.Metadata.Annotations = make(map[string]string)
+	}
+	task := j.Spec.Template.Spec.Template.Spec
+	return &runapi.Service{
+		Metadata: j.Metadata,
+		Spec: &runapi.ServiceSpec{
+			Template: &runapi.RevisionTemplate{
+				Metadata: j.Spec.Template.Metadata,
+				Spec: &runapi.RevisionSpec{
+					Containers:         task.Containers,
+					ServiceAccountName: task.ServiceAccountName,
+					Volumes:            task.Volumes,
+				},
+			},
+		},
+	}
+}
+
+// applyJobService updates the Job with the settings of the Service returned
+// by jobService, and with the job settings.
+func applyJobService(j *runapi.Job, svc *runapi.Service, settings job) {
+	j.Metadata = svc.Metadata
+	// executions are named after the job
+	svc.Spec.Template.Metadata.Name = ""
+	j.Spec.Template.Metadata = svc.Spec.Template.Metadata
+
+	exec := j.Spec.Template.Spec
+	if settings.Tasks > 0 {
+		exec.TaskCount = int64(settings.Tasks)
+	}
+	if settings.Parallelism > 0 {
+		exec.Parallelism = int64(settings.Parallelism)
+	}
+
+	task := exec.Template.Spec
+	task.Containers = svc.Spec.Template.Spec.Containers
+	for _, c := range task.Containers {
+		// tasks don't serve requests
+		c.Ports = nil
+	}
+	task.ServiceAccountName = svc.Spec.Template.Spec.ServiceAccountName
+	task.Volumes = svc.Spec.Template.Spec.Volumes
+	if settings.MaxRetries != nil {
+		task.MaxRetries = int64(*settings.MaxRetries)
+		// zero disables retries
+		task.ForceSendFields = append(task.ForceSendFields, "MaxRetries")
+	}
+	if settings.TaskTimeout > 0 {
+		task.TimeoutSeconds = int64(settings.TaskTimeout)
+	}
+}
+
+// newJob initializes a new Cloud Run Job with given properties, where apply
+// makes further changes as it would to a Service.
+func newJob(name, project, image string, envs, secrets map[string]string, sync envSync, options options, settings job, apply ...func(*runapi.Service)) *runapi.Job {
+	svc := newService(name, project, image, envs, secrets, sync, options, apply...)
+	j := &runapi.Job{
+		ApiVersion: "run.googleapis.com/v1",
+		Kind:       "Job",
+		Spec: &runapi.JobSpec{
+			Template: &runapi.ExecutionTemplateSpec{
+				Spec: &runapi.ExecutionSpec{
+					Template: &runapi.TaskTemplateSpec{Spec: &runapi.TaskSpec{}},
+				},
+			},
+		},
+	}
+	applyJobService(j, svc, settings)
+	return j
+}
+
+// deployJob creates or updates the Cloud Run Job like "gcloud run jobs deploy",
+// and waits for it to be Ready. The arguments are the same as deploy, besides
+// the job settings.
+func deployJob(project, name, image, region string, envs []string, secrets map[string]string, sync envSync, options options, settings job, apply ...func(*runapi.Service)) error {
+	envVars := parseEnv(envs)
+
+	client, err := runClient(region)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Run API client: %w", err)
+	}
+
+	j, err := getJob(project, name, region)
+	if err == nil {
+		// existing job
+		svc := patchService(jobService(j), envVars, secrets, sync, image, options, apply...)
+		applyJobService(j, svc, settings)
+		_, err = client.Namespaces.Jobs.ReplaceJob("namespaces/"+project+"/jobs/"+name, j).Do()
+		if err != nil {
+			if e, ok := err.(*googleapi.Error); ok {
+				return fmt.Errorf("failed to deploy existing Job: code=%d message=%s -- %s", e.Code, e.Message, e.Body)
+			}
+			return fmt.Errorf("failed to deploy existing Job: %w", err)
+		}
+	} else {
+		// new job
+		j = newJob(name, project, image, envVars, secrets, sync, options, settings, apply...)
+		_, err = client.Namespaces.Jobs.Create("namespaces/"+project, j).Do()
+		if err != nil {
+			if e, ok := err.(*googleapi.Error); ok {
+				return fmt.Errorf("failed to deploy a new Job: code=%d message=%s -- %s", e.Code, e.Message, e.Body)
+			}
+			return fmt.Errorf("failed to deploy a new Job: %w", err)
+		}
+	}
+	return waitJobReady(project, name, region, time.Minute*4)
+}
+
+// waitJobReady waits until the specified job reaches Ready status.
+func waitJobReady(project, name, region string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		j, err := getJob(project, name, region)
+		if err != nil {
+			return fmt.Errorf("failed to query Job for readiness: %w", err)
+		}
+		if j.Status != nil {
+			if done, err := conditionDone(j.Status.Conditions, "Ready"); done {
+				return err
+			}
+		}
+		time.Sleep(time.Second * 2)
+	}
+	return fmt.Errorf("the job did not become ready in %s, check Cloud Console for logs to see why it failed", wait)
+}
+
+// executeJob runs the job once and waits for the execution to complete. The
+// returned execution may have failed, which executionResult tells.
+func executeJob(project, name, region string) (*runapi.Execution, error) {
+	client, err := runClient(region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Run API client: %w", err)
+	}
+	exec, err := client.Namespaces.Jobs.Run(fmt.Sprintf("namespaces/%s/jobs/%s", project, name), &runapi.RunJobRequest{}).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run Job: %w", err)
+	}
+	execID := fmt.Sprintf("namespaces/%s/executions/%s", project, exec.Metadata.Name)
+	timeout := executionTimeout(exec)
+	deadline := time.Now().Add(timeout)
+	for {
+		if exec.Status != nil {
+			if done, _ := conditionDone(exec.Status.Conditions, "Completed"); done {
+				return exec, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("execution %s did not complete within %v, check its status with: gcloud run jobs executions describe %s --region=%s",
+				exec.Metadata.Name, timeout, exec.Metadata.Name, region)
+		}
+		time.Sleep(time.Second * 5)
+		exec, err = client.Namespaces.Executions.Get(execID).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query execution status (%s): %w", execID, err)
+		}
+	}
+}
+
+// executionTimeout returns how long the execution can take: each task, run
+// in waves of the parallelism, can be attempted once plus its retries, each
+// attempt bounded by the task timeout, and some margin is left for scheduling.
+func executionTimeout(exec *runapi.Execution) time.Duration {
+	taskTimeout := int64(defaultTaskTimeout)
+	retries := int64(defaultJobRetries)
+	waves := int64(1)
+	if spec := exec.Spec; spec != nil {
+		if t := spec.Template; t != nil && t.Spec != nil {
+			if t.Spec.TimeoutSeconds > 0 {
+				taskTimeout = t.Spec.TimeoutSeconds
+			}
+			if t.Spec.MaxRetries > 0 {
+				retries = t.Spec.MaxRetries
+			}
+		}
+		if spec.Parallelism > 0 && spec.TaskCount > spec.Parallelism {
+			waves = (spec.TaskCount + spec.Parallelism - 1) / spec.Parallelism
+		}
+	}
+	return time.Duration(taskTimeout*(retries+1)*waves)*time.Second + executionMargin
+}
+
+// conditionDone checks if the condition of the given type is no longer
+// unknown, and returns an error describing it if it is false.
+func conditionDone(conditions []*runapi.GoogleCloudRunV1Condition, typ string) (bool, error) {
+	for _, cond := range conditions {
+		if cond.Type != typ {
+			continue
+		}
+		switch cond.Status {
+		case "True":
+			return true, nil
+		case "False":
+			return true, fmt.Errorf("reason=%s message=%s", cond.Reason, cond.Message)
+		}
+	}
+	return false, nil
+}
+
+// executionResult describes the outcome of the completed execution, and
+// whether it succeeded.
+func executionResult(exec *runapi.Execution) (string, bool) {
+	s := exec.Status
+	if s == nil {
+		return "unknown status", false
+	}
+	_, err := conditionDone(s.Conditions, "Completed")
+	out := fmt.Sprintf("%d task(s) succeeded, %d failed, %d cancelled", s.SucceededCount, s.FailedCount, s.CancelledCount)
+	if err != nil {
+		out += " (" + err.Error() + ")"
+	}
+	return out, err == nil
+}
+
+// jobFlags returns the gcloud flags of the job settings.
+func jobFlags(settings job) []string {
+	var flags []string
+	if settings.Tasks > 0 {
+		flags = append(flags, "--tasks="+strconv.Itoa(settings.Tasks))
+	}
+	if settings.Parallelism > 0 {
+		flags = append(flags, "--parallelism="+strconv.Itoa(settings.Parallelism))
+	}
+	if settings.MaxRetries != nil {
+		flags = append(flags, "--max-retries="+strconv.Itoa(*settings.MaxRetries))
+	}
+	if settings.TaskTimeout > 0 {
+		flags = append(flags, fmt.Sprintf("--task-timeout=%ds", settings.TaskTimeout))
+	}
+	if settings.Execute {
+		flags = append(flags, "--execute-now", "--wait")
+	}
+	return flags
+}
+
+// jobOptionsFlags returns the gcloud flags of the options of a job, which
+// unlike services have no access setting.
+func jobOptionsFlags(options options) []string {
+	var flags []string
+	for _, f := range optionsToFlags(options) {
+		if !strings.HasSuffix(f, "allow-unauthenticated") {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
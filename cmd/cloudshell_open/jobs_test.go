@@ -0,0 +1,163 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+func Test_validateKind(t *testing.T) {
+	one, eleven := 1, 11
+	tests := []struct {
+		name    string
+		s       service
+		wantErr bool
+	}{
+		{"default", service{}, false},
+		{"service", service{Kind: "service"}, false},
+		{"job", service{Kind: "job", Job: &job{Tasks: 10, Parallelism: 2, MaxRetries: &one, TaskTimeout: 3600}}, false},
+		{"job without settings", service{Kind: "job", Options: options{Memory: "1Gi", Command: []string{"/bin/task"}}}, false},
+		{"unknown kind", service{Kind: "function"}, true},
+		{"job settings on service", service{Job: &job{Tasks: 1}}, true},
+		{"negative tasks", service{Kind: "job", Job: &job{Tasks: -1}}, true},
+		{"parallelism greater than tasks", service{Kind: "job", Job: &job{Tasks: 2, Parallelism: 3}}, true},
+		{"too many retries", service{Kind: "job", Job: &job{MaxRetries: &eleven}}, true},
+		{"task timeout too long", service{Kind: "job", Job: &job{TaskTimeout: 8 * 24 * 3600}}, true},
+		{"service option", service{Kind: "job", Options: options{Port: 8080}}, true},
+		{"sidecars", service{Kind: "job", Sidecars: []sidecar{{Name: "proxy", Image: "proxy"}}}, true},
+		{"probes", service{Kind: "job", Probes: &probes{}}, true},
+		{"env referring to service url", service{Kind: "job", Env: map[string]env{"CALLBACK": {Value: "${SERVICE_URL}/done"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateKind(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("validateKind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newJob(t *testing.T) {
+	zero := 0
+	settings := job{Tasks: 4, Parallelism: 2, MaxRetries: &zero, TaskTimeout: 600}
+	j := newJob("batch", "proj", "image", map[string]string{"K": "V"}, nil, envSync{managed: []string{"K"}},
+		options{Memory: "1Gi"}, settings, withServiceAccount("sa@proj.iam.gserviceaccount.com"), withLabels(map[string]string{"team": "data"}))
+
+	if j.Metadata.Name != "batch" || j.Metadata.Annotations[managedEnvsAnnotation] != "K" {
+		t.Fatalf("newJob() metadata = %#v", j.Metadata)
+	}
+	if j.Spec.Template.Metadata.Name != "" || j.Spec.Template.Metadata.Labels["team"] != "data" {
+		t.Fatalf("newJob() execution metadata = %#v", j.Spec.Template.Metadata)
+	}
+	exec := j.Spec.Template.Spec
+	if exec.TaskCount != 4 || exec.Parallelism != 2 {
+		t.Fatalf("newJob() execution spec = %#v", exec)
+	}
+	task := exec.Template.Spec
+	if task.MaxRetries != 0 || !contains(task.ForceSendFields, "MaxRetries") || task.TimeoutSeconds != 600 {
+		t.Fatalf("newJob() task spec = %#v", task)
+	}
+	if task.ServiceAccountName != "sa@proj.iam.gserviceaccount.com" {
+		t.Fatalf("newJob() service account = %q", task.ServiceAccountName)
+	}
+	c := task.Containers[0]
+	if c.Ports != nil || c.Image != "image" || c.Resources.Limits["memory"] != "1Gi" {
+		t.Fatalf("newJob() container = %#v", c)
+	}
+	if expected := []*runapi.EnvVar{{Name: "K", Value: "V"}}; !reflect.DeepEqual(c.Env, expected) {
+		t.Fatalf("newJob() env = %#v", c.Env)
+	}
+}
+
+func Test_patchJob(t *testing.T) {
+	existing := &runapi.Job{
+		Metadata: &runapi.ObjectMeta{Name: "batch", ResourceVersion: "v1"},
+		Spec: &runapi.JobSpec{Template: &runapi.ExecutionTemplateSpec{Spec: &runapi.ExecutionSpec{
+			TaskCount: 3,
+			Template: &runapi.TaskTemplateSpec{Spec: &runapi.TaskSpec{
+				MaxRetries: 2,
+				Containers: []*runapi.Container{{Image: "old", Env: []*runapi.EnvVar{{Name: "KEEP", Value: "1"}}}},
+			}},
+		}}},
+	}
+	svc := patchService(jobService(existing), map[string]string{"NEW": "2"}, nil, envSync{}, "new", options{})
+	applyJobService(existing, svc, job{Parallelism: 1})
+
+	if existing.Metadata.ResourceVersion != "v1" || existing.Spec.Template.Metadata.Name != "" {
+		t.Fatalf("metadata = %#v, %#v", existing.Metadata, existing.Spec.Template.Metadata)
+	}
+	exec := existing.Spec.Template.Spec
+	if exec.TaskCount != 3 || exec.Parallelism != 1 || exec.Template.Spec.MaxRetries != 2 {
+		t.Fatalf("execution spec = %#v, task spec = %#v", exec, exec.Template.Spec)
+	}
+	c := exec.Template.Spec.Containers[0]
+	expected := []*runapi.EnvVar{{Name: "KEEP", Value: "1"}, {Name: "NEW", Value: "2"}}
+	if c.Image != "new" || c.Ports != nil || !reflect.DeepEqual(c.Env, expected) {
+		t.Fatalf("container = %#v", c)
+	}
+}
+
+func Test_executionResult(t *testing.T) {
+	exec := &runapi.Execution{Status: &runapi.ExecutionStatus{
+		SucceededCount: 2,
+		FailedCount:    1,
+		Conditions:     []*runapi.GoogleCloudRunV1Condition{{Type: "Completed", Status: "False", Reason: "NonZeroExitCode"}},
+	}}
+	if got, ok := executionResult(exec); ok || got != "2 task(s) succeeded, 1 failed, 0 cancelled (reason=NonZeroExitCode message=)" {
+		t.Fatalf("executionResult() = %q, %v", got, ok)
+	}
+	exec.Status.FailedCount = 0
+	exec.Status.Conditions[0].Status = "True"
+	if got, ok := executionResult(exec); !ok || got != "2 task(s) succeeded, 0 failed, 0 cancelled" {
+		t.Fatalf("executionResult() = %q, %v", got, ok)
+	}
+}
+
+func Test_jobFlags(t *testing.T) {
+	three := 3
+	got := jobFlags(job{Tasks: 10, Parallelism: 5, MaxRetries: &three, TaskTimeout: 900, Execute: true})
+	expected := []string{"--tasks=10", "--parallelism=5", "--max-retries=3", "--task-timeout=900s", "--execute-now", "--wait"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("jobFlags() = %v, want %v", got, expected)
+	}
+	if got := jobOptionsFlags(options{Memory: "1Gi"}); !reflect.DeepEqual(got, []string{"--memory=1Gi"}) {
+		t.Fatalf("jobOptionsFlags() = %v", got)
+	}
+}
+
+func Test_executionTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     *runapi.ExecutionSpec
+		expected time.Duration
+	}{
+		{"defaults", nil, 4*600*time.Second + executionMargin},
+		{"task settings", &runapi.ExecutionSpec{TaskCount: 1, Template: &runapi.TaskTemplateSpec{Spec: &runapi.TaskSpec{TimeoutSeconds: 60, MaxRetries: 1}}},
+			2*60*time.Second + executionMargin},
+		{"waves of tasks", &runapi.ExecutionSpec{TaskCount: 5, Parallelism: 2, Template: &runapi.TaskTemplateSpec{Spec: &runapi.TaskSpec{TimeoutSeconds: 60, MaxRetries: 1}}},
+			3*2*60*time.Second + executionMargin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := executionTimeout(&runapi.Execution{Spec: tt.spec}); got != tt.expected {
+				t.Errorf("executionTimeout() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"google.golang.org/api/cloudkms/v1"
+	runapi "google.golang.org/api/run/v1"
+	"google.golang.org/api/secretmanager/v1"
+	serviceusage "google.golang.org/api/serviceusage/v1beta1"
+)
+
+const (
+	encryptionKeyAnnotation = "run.googleapis.com/encryption-key"
+	kmsEncrypterDecrypter   = "roles/cloudkms.cryptoKeyEncrypterDecrypter"
+)
+
+var kmsKeyPattern = regexp.MustCompile(`^projects/[^/]+/locations/([^/]+)/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// validateKMSKey checks that the key is the resource name of a Cloud KMS key.
+func validateKMSKey(key string) error {
+	if key != "" && !kmsKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid kms-key %q, must be like projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY", key)
+	}
+	return nil
+}
+
+// kmsKeyLocation returns the location of the key.
+func kmsKeyLocation(key string) string {
+	m := kmsKeyPattern.FindStringSubmatch(key)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// kmsRegion returns the region to deploy to with the key, as the resources it
+// encrypts must be in its location: the location of the key if region is
+// empty, or region if the key is in it.
+func kmsRegion(key, region string) (string, error) {
+	loc := kmsKeyLocation(key)
+	// regions are like us-central1, unlike global and multi-regions like us
+	if !strings.Contains(loc, "-") {
+		return "", fmt.Errorf("kms-key %s is in location %s, and must be in the region of the resources it encrypts", key, loc)
+	}
+	if region != "" && region != loc {
+		return "", fmt.Errorf("kms-key %s is in location %s, and can't encrypt resources in region %s", key, loc, region)
+	}
+	return loc, nil
+}
+
+// kmsServiceAgents returns the services whose service agents encrypt data with
+// the key: Cloud Run and Artifact Registry, and Secret Manager if secrets are
+// used.
+func kmsServiceAgents(secrets bool) []string {
+	out := []string{"run.googleapis.com", "artifactregistry.googleapis.com"}
+	if secrets {
+		out = append(out, "secretmanager.googleapis.com")
+	}
+	return out
+}
+
+// serviceAgentMember returns the IAM member of the service agent of the API in
+// the project.
+func serviceAgentMember(projectNumber int64, api string) string {
+	domain := map[string]string{
+		"run.googleapis.com":              "serverless-robot-prod.iam.gserviceaccount.com",
+		"artifactregistry.googleapis.com": "gcp-sa-artifactregistry.iam.gserviceaccount.com",
+		"secretmanager.googleapis.com":    "gcp-sa-secretmanager.iam.gserviceaccount.com",
+	}[api]
+	return fmt.Sprintf("serviceAccount:service-%d@%s", projectNumber, domain)
+}
+
+// missingKeyGrants returns the members that can't use the key in the policy.
+func missingKeyGrants(policy *cloudkms.Policy, members []string) []string {
+	has := make(map[string]bool)
+	for _, b := range policy.Bindings {
+		if b.Role != kmsEncrypterDecrypter || b.Condition != nil {
+			continue
+		}
+		for _, m := range b.Members {
+			has[m] = true
+		}
+	}
+	var out []string
+	for _, m := range members {
+		if !has[m] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// checkKMSKey makes sure that the service agents can use the key, and offers
+// to grant them access if they can't.
+func checkKMSKey(project, key string, secrets bool) error {
+	number, err := projectNumber(project)
+	if err != nil {
+		return err
+	}
+	var members []string
+	for _, api := range kmsServiceAgents(secrets) {
+		if err := ensureServiceAgent(number, api); err != nil {
+			return err
+		}
+		members = append(members, serviceAgentMember(number, api))
+	}
+
+	client, err := cloudkms.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Cloud KMS client: %w", err)
+	}
+	policy, err := client.Projects.Locations.KeyRings.CryptoKeys.GetIamPolicy(key).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get IAM policy of key %s: %w", key, err)
+	}
+	missing := missingKeyGrants(policy, members)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	fmt.Printf("%s These service agents need the %s role on key %s to use it:\n", infoPrefix, kmsEncrypterDecrypter, key)
+	for _, m := range missing {
+		fmt.Printf("  %s\n", strings.TrimPrefix(m, "serviceAccount:"))
+	}
+	var grant bool
+	if err := survey.AskOne(&survey.Confirm{
+		Default: true,
+		Message: "Grant them access to the key?",
+	}, &grant, surveyIconOpts); err != nil {
+		return fmt.Errorf("could not prompt for confirmation: %+v", err)
+	}
+	if !grant {
+		return fmt.Errorf("the service agents can't use key %s, grant them access with: gcloud kms keys add-iam-policy-binding %s --member=MEMBER --role=%s",
+			key, key, kmsEncrypterDecrypter)
+	}
+
+	policy.Bindings = append(policy.Bindings, &cloudkms.Binding{Role: kmsEncrypterDecrypter, Members: missing})
+	if _, err := client.Projects.Locations.KeyRings.CryptoKeys.SetIamPolicy(key, &cloudkms.SetIamPolicyRequest{Policy: policy}).Do(); err != nil {
+		return fmt.Errorf("failed to grant access to key %s: %w", key, err)
+	}
+	return nil
+}
+
+// ensureServiceAgent creates the service agent of the API if it doesn't exist
+// yet, as it may not until the API is first used.
+func ensureServiceAgent(projectNumber int64, api string) error {
+	client, err := serviceusage.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Service Usage client: %w", err)
+	}
+	op, err := client.Services.GenerateServiceIdentity(fmt.Sprintf("projects/%d/services/%s", projectNumber, api)).Do()
+	if err != nil {
+		return fmt.Errorf("failed to create the service agent of %s: %w", api, err)
+	}
+	opID := op.Name
+	for !op.Done {
+		time.Sleep(time.Second)
+		op, err = client.Operations.Get(opID).Do()
+		if err != nil {
+			return fmt.Errorf("failed to query operation status (%s): %w", opID, err)
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("failed to create the service agent of %s: %s", api, op.Error.Message)
+	}
+	return nil
+}
+
+// secretReplication returns the replication of secrets encrypted with the key,
+// if any: automatic for global keys, otherwise in the location of the key.
+func secretReplication(key string) *secretmanager.Replication {
+	if key == "" {
+		return &secretmanager.Replication{Automatic: &secretmanager.Automatic{}}
+	}
+	cmek := &secretmanager.CustomerManagedEncryption{KmsKeyName: key}
+	location := kmsKeyLocation(key)
+	if location == "global" {
+		return &secretmanager.Replication{Automatic: &secretmanager.Automatic{CustomerManagedEncryption: cmek}}
+	}
+	return &secretmanager.Replication{UserManaged: &secretmanager.UserManaged{
+		Replicas: []*secretmanager.Replica{{Location: location, CustomerManagedEncryption: cmek}},
+	}}
+}
+
+// withEncryptionKey encrypts the revisions of the Service with the key.
+func withEncryptionKey(key string) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		svc.Spec.Template.Metadata.Annotations[encryptionKeyAnnotation] = key
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+const testKey = "projects/p/locations/us-central1/keyRings/ring/cryptoKeys/key"
+
+func Test_validateKMSKey(t *testing.T) {
+	for _, key := range []string{"", testKey, "projects/p/locations/global/keyRings/r/cryptoKeys/k"} {
+		if err := validateKMSKey(key); err != nil {
+			t.Errorf("validateKMSKey(%q) = %v", key, err)
+		}
+	}
+	for _, key := range []string{"key", "projects/p/locations/us-central1/keyRings/ring", testKey + "/cryptoKeyVersions/1"} {
+		if err := validateKMSKey(key); err == nil {
+			t.Errorf("validateKMSKey(%q) succeeded", key)
+		}
+	}
+	if got := kmsKeyLocation(testKey); got != "us-central1" {
+		t.Errorf("kmsKeyLocation() = %q", got)
+	}
+}
+
+func Test_kmsRegion(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{"region of the key", testKey, "", "us-central1", false},
+		{"same region", testKey, "us-central1", "us-central1", false},
+		{"other region", testKey, "europe-west1", "", true},
+		{"global key", "projects/p/locations/global/keyRings/r/cryptoKeys/k", "", "", true},
+		{"multi-region key", "projects/p/locations/us/keyRings/r/cryptoKeys/k", "us-central1", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kmsRegion(tt.key, tt.region)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("kmsRegion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("kmsRegion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_missingKeyGrants(t *testing.T) {
+	run := serviceAgentMember(123, "run.googleapis.com")
+	if run != "serviceAccount:service-123@serverless-robot-prod.iam.gserviceaccount.com" {
+		t.Fatalf("serviceAgentMember() = %q", run)
+	}
+	ar := serviceAgentMember(123, "artifactregistry.googleapis.com")
+	sm := serviceAgentMember(123, "secretmanager.googleapis.com")
+
+	policy := &cloudkms.Policy{Bindings: []*cloudkms.Binding{
+		{Role: kmsEncrypterDecrypter, Members: []string{run}},
+		{Role: "roles/cloudkms.viewer", Members: []string{ar}},
+		{Role: kmsEncrypterDecrypter, Members: []string{sm}, Condition: &cloudkms.Expr{Expression: "false"}},
+	}}
+	if got, expected := missingKeyGrants(policy, []string{run, ar, sm}), []string{ar, sm}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("missingKeyGrants() = %v, want %v", got, expected)
+	}
+}
+
+func Test_secretReplication(t *testing.T) {
+	if got := secretReplication(""); got.Automatic == nil || got.Automatic.CustomerManagedEncryption != nil {
+		t.Fatalf("secretReplication() without key = %#v", got)
+	}
+
+	global := "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+	if got := secretReplication(global); got.Automatic == nil || got.Automatic.CustomerManagedEncryption.KmsKeyName != global {
+		t.Fatalf("secretReplication() with global key = %#v", got)
+	}
+
+	expected := &secretmanager.Replication{UserManaged: &secretmanager.UserManaged{
+		Replicas: []*secretmanager.Replica{{
+			Location:                  "us-central1",
+			CustomerManagedEncryption: &secretmanager.CustomerManagedEncryption{KmsKeyName: testKey},
+		}},
+	}}
+	if got := secretReplication(testKey); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("secretReplication() with regional key = %#v", got)
+	}
+}
+
+func Test_withEncryptionKey(t *testing.T) {
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{}, withEncryptionKey(testKey))
+	if got := svc.Spec.Template.Metadata.Annotations[encryptionKeyAnnotation]; got != testKey {
+		t.Fatalf("newService() %s = %q", encryptionKeyAnnotation, got)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// koBuild builds and pushes the image of the Go application with ko, and
+// returns its digest.
+func koBuild(dir, image string) (string, error) {
+	cmd := exec.Command("ko", "build", "--bare", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "KO_DOCKER_REPO="+image)
+	b, err := cmd.Output()
+	if err != nil {
+		var stderr []byte
+		var e *exec.ExitError
+		if errors.As(err, &e) {
+			stderr = e.Stderr
+		}
+		return "", fmt.Errorf("ko build failed: %v, output:\n%s", err, string(stderr))
+	}
+	return koDigest(string(b)), nil
+}
+
+// koDigest returns the digest of the image reference printed by ko build, if
+// any.
+func koDigest(output string) string {
+	ref := strings.TrimSpace(output)
+	if i := strings.LastIndex(ref, "\n"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[i+1:]
+	}
+	return ""
+}
+
+// koBuilder builds and pushes the image of Go applications with ko.
+type koBuilder struct{}
+
+func (koBuilder) Detect(dir string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check for go.mod in the repo: %v", err)
+	}
+	return true, nil
+}
+
+func (b koBuilder) Check(dir string) error {
+	if ok, err := b.Detect(dir); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("there is no go.mod in %s", dir)
+	}
+	if _, err := exec.LookPath("ko"); err != nil {
+		return fmt.Errorf("ko is not installed")
+	}
+	return nil
+}
+
+func (koBuilder) Describe(req buildRequest) (string, string) {
+	return "build this application with ko", fmt.Sprintf("cd %s && KO_DOCKER_REPO=%s ko build --bare .", req.dir, req.image)
+}
+
+func (koBuilder) Build(req buildRequest) (string, error) { return koBuild(req.dir, req.image) }
+
+func (koBuilder) NeedsPush() bool { return false }
@@ -0,0 +1,34 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func Test_koDigest(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{"us-docker.pkg.dev/p/r/app@sha256:abc\n", "sha256:abc"},
+		{"warning\nus-docker.pkg.dev/p/r/app@sha256:def", "sha256:def"},
+		{"us-docker.pkg.dev/p/r/app:latest\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := koDigest(tt.output); got != tt.expected {
+			t.Errorf("koDigest(%q) = %q, want %q", tt.output, got, tt.expected)
+		}
+	}
+}
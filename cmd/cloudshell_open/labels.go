@@ -0,0 +1,113 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+const (
+	// managedByLabel and sourceRepoLabel are set on the resources created by
+	// the button.
+	managedByLabel  = "managed-by"
+	sourceRepoLabel = "source-repo"
+
+	managedByValue = "cloud-run-button"
+
+	// maxLabels is the maximum number of labels of a resource.
+	maxLabels = 64
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[\p{Ll}\p{Lo}][\p{Ll}\p{Lo}\p{N}_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}_-]{0,63}$`)
+	invalidLabelChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// validateLabels checks the labels declared in app.json against the rules of
+// Google Cloud labels, leaving room for the labels set by the button.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels-2 {
+		return fmt.Errorf("too many labels, at most %d can be declared", maxLabels-2)
+	}
+	for _, k := range sortedKeys(labels) {
+		if k == managedByLabel || k == sourceRepoLabel {
+			return fmt.Errorf("label %q is set by the button and can't be declared", k)
+		}
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q, must start with a lowercase letter and have at most 63 lowercase letters, digits, underscores and hyphens", k)
+		}
+		if !labelValuePattern.MatchString(labels[k]) {
+			return fmt.Errorf("invalid value %q of label %q, must have at most 63 lowercase letters, digits, underscores and hyphens", labels[k], k)
+		}
+	}
+	return nil
+}
+
+// resourceLabels returns the labels of the resources deployed from the
+// repository: the declared ones and the ones set by the button.
+func resourceLabels(declared map[string]string, repoURL string) map[string]string {
+	out := map[string]string{managedByLabel: managedByValue}
+	if v := labelValue(repoURL); v != "" {
+		out[sourceRepoLabel] = v
+	}
+	for k, v := range declared {
+		out[k] = v
+	}
+	return out
+}
+
+// labelValue turns the repository URL into a valid label value, e.g.
+// https://github.com/google/repo.git into github-com-google-repo.
+func labelValue(repoURL string) string {
+	v := strings.ToLower(repoURL)
+	if i := strings.Index(v, "://"); i >= 0 {
+		v = v[i+3:]
+	}
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "/"), ".git")
+	v = strings.Trim(invalidLabelChars.ReplaceAllString(v, "-"), "-")
+	if len(v) > 63 {
+		v = strings.TrimRight(v[:63], "-")
+	}
+	return v
+}
+
+// withLabels adds the labels to the Service and its revisions, keeping the
+// labels added by other means.
+func withLabels(labels map[string]string) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		for _, meta := range []*runapi.ObjectMeta{svc.Metadata, svc.Spec.Template.Metadata} {
+			if meta.Labels == nil {
+				meta.Labels = make(map[string]string)
+			}
+			for k, v := range labels {
+				meta.Labels[k] = v
+			}
+		}
+	}
+}
+
+// labelsFlag returns the gcloud flag adding the labels.
+func labelsFlag(labels map[string]string) string {
+	var pairs []string
+	for _, k := range sortedKeys(labels) {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return "--update-labels=" + strings.Join(pairs, ",")
+}
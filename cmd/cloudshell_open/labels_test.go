@@ -0,0 +1,96 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_validateLabels(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i < maxLabels-1; i++ {
+		tooMany[fmt.Sprintf("l%d", i)] = ""
+	}
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", map[string]string{"team": "finance", "cost-center": "cc_123", "empty": ""}, false},
+		{"international", map[string]string{"équipe": "données"}, false},
+		{"uppercase key", map[string]string{"Team": "x"}, true},
+		{"key starting with a digit", map[string]string{"1team": "x"}, true},
+		{"key too long", map[string]string{strings.Repeat("k", 64): "x"}, true},
+		{"uppercase value", map[string]string{"team": "Finance"}, true},
+		{"value with a dot", map[string]string{"version": "1.0"}, true},
+		{"value too long", map[string]string{"team": strings.Repeat("v", 64)}, true},
+		{"reserved key", map[string]string{"managed-by": "me"}, true},
+		{"too many", tooMany, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLabels(tt.labels); (err != nil) != tt.wantErr {
+				t.Errorf("validateLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_labelValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://github.com/GoogleCloudPlatform/cloud-run-hello.git", "github-com-googlecloudplatform-cloud-run-hello"},
+		{"https://gitlab.com/group/sub_group/repo/", "gitlab-com-group-sub_group-repo"},
+		{"git@github.com:user/repo.git", "git-github-com-user-repo"},
+		{"https://github.com/" + strings.Repeat("a", 50) + "/-repo", "github-com-" + strings.Repeat("a", 50)},
+	}
+	for _, tt := range tests {
+		got := labelValue(tt.in)
+		if got != tt.want {
+			t.Errorf("labelValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !labelValuePattern.MatchString(got) {
+			t.Errorf("labelValue(%q) = %q is not a valid label value", tt.in, got)
+		}
+	}
+}
+
+func Test_withLabels(t *testing.T) {
+	labels := resourceLabels(map[string]string{"team": "finance"}, "https://github.com/google/repo")
+	expected := map[string]string{"managed-by": "cloud-run-button", "source-repo": "github-com-google-repo", "team": "finance"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("resourceLabels() = %v, want %v", labels, expected)
+	}
+
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{}, withLabels(labels))
+	if !reflect.DeepEqual(svc.Metadata.Labels, expected) || !reflect.DeepEqual(svc.Spec.Template.Metadata.Labels, expected) {
+		t.Fatalf("newService() labels = %v, %v", svc.Metadata.Labels, svc.Spec.Template.Metadata.Labels)
+	}
+
+	svc.Metadata.Labels = map[string]string{"team": "ops", "env": "prod"}
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{}, withLabels(labels))
+	if got := svc.Metadata.Labels; len(got) != 4 || got["team"] != "finance" || got["env"] != "prod" {
+		t.Fatalf("patchService() labels = %v", got)
+	}
+
+	if got := labelsFlag(labels); got != "--update-labels=managed-by=cloud-run-button,source-repo=github-com-google-repo,team=finance" {
+		t.Fatalf("labelsFlag() = %q", got)
+	}
+}
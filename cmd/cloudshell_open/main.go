@@ -27,6 +27,7 @@ import (
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/GoogleCloudPlatform/cloud-run-button/cmd/instrumentless"
+	runapi "google.golang.org/api/run/v1"
 	"google.golang.org/api/transport"
 
 	"cloud.google.com/go/compute/metadata"
@@ -41,6 +42,8 @@ const (
 	flPage          = "page"
 	flForceNewClone = "force_new_clone"
 	flContext       = "context"
+	flSyncEnv       = "sync_env"
+	flEnvFile       = "env_file"
 
 	reauthCredentialsWaitTimeout     = time.Minute * 2
 	reauthCredentialsPollingInterval = time.Second
@@ -76,6 +79,8 @@ func init() {
 	flags.StringVar(&opts.gitBranch, flGitBranch, "", "(optional) branch/revision to use from the git repo")
 	flags.StringVar(&opts.subDir, flSubDir, "", "(optional) sub-directory to deploy in the repo")
 	flags.StringVar(&opts.context, flContext, "", "(optional) arbitrary context")
+	flags.BoolVar(&opts.syncEnv, flSyncEnv, false, "(optional) remove environment variables no longer declared in app.json")
+	flags.StringVar(&opts.envFile, flEnvFile, "", "(optional) .env file with the values of environment variables to use instead of prompting")
 
 	_ = flags.String(flPage, "", "ignored")
 	_ = flags.Bool(flForceNewClone, false, "ignored")
@@ -103,6 +108,15 @@ type runOpts struct {
 	gitBranch string
 	subDir    string
 	context   string
+	syncEnv   bool
+	envFile   string
+}
+
+// resourceConfig holds the settings of the resources created for the app.
+type resourceConfig struct {
+	labels map[string]string
+	// kmsKey is the Cloud KMS key encrypting the resources, if any.
+	kmsKey string
 }
 
 func logProgress(msg, endMsg, errMsg string) func(bool) {
@@ -125,14 +139,21 @@ func logProgress(msg, endMsg, errMsg string) func(bool) {
 func run(opts runOpts) error {
 	ctx := context.Background()
 	highlight := func(s string) string { return color.CyanString(s) }
-	parameter := func(s string) string { return parameterLabel.Sprint(s) }
-	cmdColor := color.New(color.FgHiBlue)
 
 	repo := opts.repoURL
 	if repo == "" {
 		return fmt.Errorf("--%s not specified", flRepoURL)
 	}
 
+	var envAnswers map[string]string
+	if opts.envFile != "" {
+		var err error
+		envAnswers, err = readDotEnv(opts.envFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	trusted := os.Getenv("TRUSTED_ENVIRONMENT") == "true"
 	if !trusted {
 		fmt.Printf("%s You launched this custom Cloud Shell image as \"Do not trust\".\n"+
@@ -195,6 +216,15 @@ func run(opts runOpts) error {
 		return fmt.Errorf("error attempting to read the app.json from the cloned repository: %+v", err)
 	}
 
+	region := os.Getenv("GOOGLE_CLOUD_REGION")
+	if appFile.KMSKey != "" {
+		// checked before any work starts, as the resources can only be
+		// encrypted with a key in their region
+		if region, err = kmsRegion(appFile.KMSKey, region); err != nil {
+			return err
+		}
+	}
+
 	project := os.Getenv("GOOGLE_CLOUD_PROJECT")
 
 	for project == "" {
@@ -273,18 +303,25 @@ func run(opts runOpts) error {
 		return err
 	}
 
+	services, err := appFile.services(filepath.Base(appDir))
+	if err != nil {
+		return err
+	}
+
 	end = logProgress(
 		fmt.Sprintf("Enabling Cloud Run API on project %s...", highlight(project)),
 		fmt.Sprintf("Enabled Cloud Run API on project %s.", highlight(project)),
 		fmt.Sprintf("Failed to enable required APIs on project %s.", highlight(project)))
-	err = enableAPIs(project, []string{"run.googleapis.com", "artifactregistry.googleapis.com"})
+	apis := requiredAPIs(services)
+	if appFile.KMSKey != "" {
+		apis = append(apis, "cloudkms.googleapis.com")
+	}
+	err = enableAPIs(project, apis)
 	end(err == nil)
 	if err != nil {
 		return err
 	}
 
-	region := os.Getenv("GOOGLE_CLOUD_REGION")
-
 	if region == "" {
 		region, err = promptDeploymentRegion(ctx, project)
 		if err != nil {
@@ -292,200 +329,767 @@ func run(opts runOpts) error {
 		}
 	}
 
+	res := resourceConfig{labels: resourceLabels(appFile.Labels, repo), kmsKey: appFile.KMSKey}
+	if res.kmsKey != "" {
+		if err := checkKMSKey(project, res.kmsKey, contains(apis, "secretmanager.googleapis.com")); err != nil {
+			return err
+		}
+	}
+
 	end = logProgress(
 		fmt.Sprintf("Setting up %s in region %s (if it doesn't already exist)", highlight(artifactRegistry), highlight(region)),
 		fmt.Sprintf("Set up %s in region %s (if it doesn't already exist)", highlight(artifactRegistry), highlight(region)),
 		"Failed to setup artifact registry.")
-	err = createArtifactRegistry(project, region, artifactRegistry)
+	err = createArtifactRegistry(project, region, artifactRegistry, res.labels, res.kmsKey)
 	end(err == nil)
 	if err != nil {
 		return err
 	}
 
-	repoName := filepath.Base(appDir)
-	serviceName := repoName
-	if appFile.Name != "" {
-		serviceName = appFile.Name
+	urls := make(map[string]string)
+	saved := newSavedEnvs()
+	var deployed, jobs []string
+	for _, svc := range services {
+		if opts.syncEnv {
+			svc.Options.SyncEnv = &opts.syncEnv
+		}
+
+		var depEnvs []string
+		for _, dep := range svc.DependsOn {
+			depEnvs = append(depEnvs, fmt.Sprintf("%s=%s", serviceURLEnv(dep), urls[dep]))
+		}
+
+		// the services of an app.json with "services" can declare the same
+		// variables, so their values are scoped in the env file
+		answers, svcSaved := envAnswers, saved
+		if len(appFile.Services) > 0 {
+			prefix := dotEnvPrefix(svc.Name)
+			answers, svcSaved = scopedAnswers(envAnswers, prefix), saved.scoped(prefix)
+		}
+
+		serviceName, url, err := runService(ctx, project, region, appDir, svc, depEnvs, answers, svcSaved, res)
+		if err != nil {
+			if len(services) > 1 {
+				return fmt.Errorf("failed to deploy service %q: %w", svc.Name, err)
+			}
+			return err
+		}
+		if svc.isJob() {
+			jobs = append(jobs, serviceName)
+			continue
+		}
+		if url == "" {
+			// deployed through a builder that manages the services itself
+			continue
+		}
+		urls[svc.Name] = url
+		deployed = append(deployed, serviceName)
 	}
-	serviceName, err = tryFixServiceName(serviceName)
-	if err != nil {
+
+	envFile := opts.envFile
+	if envFile == "" {
+		envFile = filepath.Base(appDir) + ".env"
+		if home, err := os.UserHomeDir(); err == nil {
+			envFile = filepath.Join(home, envFile)
+		}
+	}
+	if err := promptSaveEnvFile(envFile, saved); err != nil {
 		return err
 	}
 
+	if len(jobs) > 0 {
+		fmt.Printf("* Manage the jobs of this application at Cloud Console:\n")
+		for _, jobName := range jobs {
+			fmt.Printf("\t")
+			color.New(color.Underline, color.Bold).Printf("https://console.cloud.google.com/run/jobs/details/%s/%s/executions?project=%s\n", region, jobName, project)
+		}
+		fmt.Printf("* Run a job with:\n")
+		fmt.Printf("\tgcloud run jobs execute JOB --project=%s --region=%s\n", project, region)
+	}
+
+	if len(deployed) == 0 {
+		return nil
+	}
+
+	fmt.Printf("* This application is billed only when it's handling requests.\n")
+	fmt.Printf("* Manage this application at Cloud Console:\n")
+	for _, serviceName := range deployed {
+		fmt.Printf("\t")
+		color.New(color.Underline, color.Bold).Printf("https://console.cloud.google.com/run/detail/%s/%s?project=%s\n", region, serviceName, project)
+	}
+	fmt.Printf("* Learn more about Cloud Run:\n\t")
+	color.New(color.Underline, color.Bold).Println("https://cloud.google.com/run/docs")
+	if len(services) == 1 {
+		fmt.Printf(successPrefix+" %s%s\n",
+			color.New(color.Bold).Sprint("Your application is now live here:\n\t"),
+			color.New(color.Bold, color.FgGreen, color.Underline).Sprint(urls[services[0].Name]))
+		return nil
+	}
+	fmt.Printf(successPrefix+" %s\n", color.New(color.Bold).Sprint("Your application is now live here:"))
+	for _, svc := range services {
+		if url, ok := urls[svc.Name]; ok {
+			fmt.Printf("\t%s: %s\n", svc.Name, color.New(color.Bold, color.FgGreen, color.Underline).Sprint(url))
+		}
+	}
+	return nil
+}
+
+// runService builds and deploys a single service of the application with the
+// specified extra environment variables, and returns the name and URL of the
+// deployed Cloud Run service. answers holds the values of the environment
+// variables to use instead of prompting, and the values of the environment
+// variables are recorded in saved.
+func runService(ctx context.Context, project, region, appDir string, svc service, extraEnvs []string, answers map[string]string, saved *savedEnvs, res resourceConfig) (string, string, error) {
+	highlight := func(s string) string { return color.CyanString(s) }
+	parameter := func(s string) string { return parameterLabel.Sprint(s) }
+	cmdColor := color.New(color.FgHiBlue)
+
+	serviceDir := appDir
+	if svc.Dir != "" {
+		serviceDir = filepath.Join(appDir, svc.Dir)
+		if fi, err := os.Stat(serviceDir); err != nil {
+			return "", "", fmt.Errorf("failed to check directory of service %q: %v", svc.Name, err)
+		} else if !fi.IsDir() {
+			return "", "", fmt.Errorf("directory %s of service %q is not a directory", serviceDir, svc.Name)
+		}
+	}
+
+	serviceName, err := tryFixServiceName(svc.Name)
+	if err != nil {
+		return "", "", err
+	}
+
 	image := fmt.Sprintf("%s-docker.pkg.dev/%s/%s/%s", region, project, artifactRegistry, serviceName)
 
-	existingEnvVars := make(map[string]struct{})
+	existingEnvVars := make(map[string]string)
 	// todo(jamesward) actually determine if the service exists instead of assuming it doesn't if we get an error
-	existingService, err := getService(project, serviceName, region)
-	if err == nil {
+	var existingService *runapi.Service
+	if svc.isJob() {
+		if existingJob, err := getJob(project, serviceName, region); err == nil {
+			// job exists, seen as a service to reuse the env var handling
+			existingService = jobService(existingJob)
+		}
+	} else if s, err := getService(project, serviceName, region); err == nil {
 		// service exists
-		existingEnvVars, err = envVars(project, serviceName, region)
+		existingService = s
+	}
+	if existingService != nil {
+		existingEnvVars = serviceEnvs(existingService)
 	}
 
-	neededEnvs := needEnvs(appFile.Env, existingEnvVars)
+	var sqlSetup *cloudSQLSetup
+	if svc.CloudSQL != nil {
+		sqlSetup, err = planCloudSQL(project, region, serviceName, *svc.CloudSQL, existingService, existingEnvVars)
+		if err != nil {
+			return "", "", err
+		}
+		extraEnvs = append(extraEnvs, sqlSetup.envs()...)
+	}
 
-	envs, err := promptOrGenerateEnvs(neededEnvs)
+	// existing env vars with a value in the env file are updated without asking
+	var offeredEnvs, answeredEnvs []string
+	for _, k := range existingEnvs(svc.Env, existingEnvVars) {
+		if _, ok := answers[k]; ok {
+			answeredEnvs = append(answeredEnvs, k)
+		} else {
+			offeredEnvs = append(offeredEnvs, k)
+		}
+	}
+	updateEnvs, err := promptUpdateEnvs(offeredEnvs)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	neededEnvs := needEnvs(svc.Env, existingEnvVars, append(updateEnvs, answeredEnvs...))
+
+	var sync envSync
+	for k := range svc.Env {
+		sync.managed = append(sync.managed, k)
+	}
+	for k := range parseEnv(extraEnvs) {
+		sync.managed = append(sync.managed, k)
+	}
+	if sqlSetup != nil {
+		sync.managed = append(sync.managed, sqlPasswordEnv)
+	}
+	var removedEnvVars, removedSecrets []string
+	if existingService != nil && svc.Options.SyncEnv != nil && *svc.Options.SyncEnv {
+		for _, e := range removedEnvs(existingService, sync.managed) {
+			if isSecretRef(e) {
+				removedSecrets = append(removedSecrets, e.Name)
+			} else {
+				removedEnvVars = append(removedEnvVars, e.Name)
+			}
+		}
+		removed := append(append([]string(nil), removedEnvVars...), removedSecrets...)
+		ok, err := confirmRemoveEnvs(removed)
+		if err != nil {
+			return "", "", err
+		}
+		if ok {
+			sync.remove = removed
+		} else {
+			// keep managing them, so that they are offered for removal again
+			sync.managed = append(sync.managed, removed...)
+			removedEnvVars, removedSecrets = nil, nil
+		}
 	}
 
 	projectEnv := fmt.Sprintf("GOOGLE_CLOUD_PROJECT=%s", project)
 	regionEnv := fmt.Sprintf("GOOGLE_CLOUD_REGION=%s", region)
 	serviceEnv := fmt.Sprintf("K_SERVICE=%s", serviceName)
 	imageEnv := fmt.Sprintf("IMAGE_URL=%s", image)
-	appDirEnv := fmt.Sprintf("APP_DIR=%s", appDir)
+	appDirEnv := fmt.Sprintf("APP_DIR=%s", serviceDir)
 	inheritedEnv := os.Environ()
 
-	hookEnvs := append([]string{projectEnv, regionEnv, serviceEnv, imageEnv, appDirEnv}, envs...)
-	for key, value := range existingEnvVars {
-		hookEnvs = append(hookEnvs, fmt.Sprintf("%s=%s", key, value))
+	contextEnvs := append([]string{projectEnv, regionEnv, serviceEnv, imageEnv, appDirEnv}, extraEnvs...)
+	if existingService != nil && existingService.Status != nil && existingService.Status.Url != "" {
+		contextEnvs = append(contextEnvs, fmt.Sprintf("%s=%s", serviceURLVar, existingService.Status.Url))
 	}
-	hookEnvs = append(hookEnvs, inheritedEnv...)
 
-	pushImage := true
+	vars := make(map[string]string)
+	for k, v := range existingEnvVars {
+		vars[k] = v
+	}
+	for k, v := range parseEnv(contextEnvs) {
+		vars[k] = v
+	}
+	if sqlSetup != nil {
+		vars[sqlPasswordEnv] = sqlSetup.password
+	}
+	envs, deferredEnvs, err := promptOrGenerateEnvs(neededEnvs, vars, answers)
+	if err != nil {
+		return "", "", err
+	}
+	saved.add(envs, neededEnvs, answers)
+	envs = append(envs, extraEnvs...)
 
-	if appFile.Hooks.PreBuild.Commands != nil {
-		err = runScripts(appDir, appFile.Hooks.PreBuild.Commands, hookEnvs)
+	var runtimeAccount string
+	if existingService != nil {
+		runtimeAccount = existingService.Spec.Template.Spec.ServiceAccountName
+	}
+	applyService := []func(*runapi.Service){withLabels(res.labels)}
+	if res.kmsKey != "" {
+		applyService = append(applyService, withEncryptionKey(res.kmsKey))
+	}
+	if svc.ServiceAccount != nil {
+		end := logProgress(fmt.Sprintf("Setting up the service account of service %s...", highlight(serviceName)),
+			fmt.Sprintf("Set up the service account of service %s.", highlight(serviceName)),
+			"Failed to set up the service account.")
+		sa := *svc.ServiceAccount
+		if sqlSetup != nil && !contains(sa.Roles, cloudSQLClientRole) {
+			sa.Roles = append(append([]string(nil), sa.Roles...), cloudSQLClientRole)
+		}
+		email, otherRoles, err := setupServiceAccount(project, serviceName, sa)
+		end(err == nil)
+		if err != nil {
+			return "", "", err
+		}
+		if len(otherRoles) > 0 {
+			fmt.Printf("%s The service account %s also has roles that were not granted from app.json: %s\n",
+				infoPrefix, highlight(email), strings.Join(otherRoles, ", "))
+		}
+		runtimeAccount = email
+		applyService = append(applyService, withServiceAccount(email))
+	}
+	if sqlSetup != nil {
+		msg := fmt.Sprintf("Setting up database %s on Cloud SQL instance %s...", highlight(sqlSetup.database), highlight(sqlSetup.instance))
+		if sqlSetup.create {
+			msg = fmt.Sprintf("Creating Cloud SQL instance %s with database %s (this can take several minutes)...", highlight(sqlSetup.instance), highlight(sqlSetup.database))
+		}
+		end := logProgress(msg,
+			fmt.Sprintf("Set up database %s on Cloud SQL instance %s.", highlight(sqlSetup.database), highlight(sqlSetup.instance)),
+			"Failed to set up the Cloud SQL database.")
+		err := provisionCloudSQL(project, *svc.CloudSQL, sqlSetup, res.labels)
+		end(err == nil)
+		if err != nil {
+			return "", "", err
+		}
+		applyService = append(applyService, withCloudSQLInstance(sqlSetup.connectionName))
+	}
+	if len(svc.Volumes) > 0 {
+		end := logProgress(fmt.Sprintf("Setting up the volumes of service %s...", highlight(serviceName)),
+			fmt.Sprintf("Set up the volumes of service %s.", highlight(serviceName)),
+			"Failed to set up the volumes.")
+		err := setupVolumes(project, region, serviceName, serviceDir, runtimeAccount, svc.Volumes, res)
+		end(err == nil)
+		if err != nil {
+			return "", "", err
+		}
+		applyService = append(applyService, withVolumes(project, serviceName, svc.Volumes))
+	}
+	storeSecretEnvs := func(values map[string]string) (map[string]string, error) {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		end := logProgress("Storing secret environment variables in Secret Manager...",
+			"Stored secret environment variables in Secret Manager.",
+			"Failed to store secret environment variables in Secret Manager.")
+		secrets, err := storeSecrets(project, serviceName, runtimeAccount, values, res)
+		end(err == nil)
+		return secrets, err
 	}
 
-	skipBuild := appFile.Build.Skip != nil && *appFile.Build.Skip == true
+	deployEnvs, secretValues := splitSecretEnvs(envs, neededEnvs)
+	if sqlSetup != nil && sqlSetup.setPassword {
+		secretValues[sqlPasswordEnv] = sqlSetup.password
+	}
+	secrets, err := storeSecretEnvs(secretValues)
+	if err != nil {
+		return "", "", err
+	}
 
-	skipDocker := false
-	skipJib := false
+	hookEnvs := []string{projectEnv, regionEnv, serviceEnv, imageEnv, appDirEnv}
+	for key, value := range existingEnvVars {
+		hookEnvs = append(hookEnvs, fmt.Sprintf("%s=%s", key, value))
+	}
+	hookEnvs = append(hookEnvs, envs...)
+	if sqlSetup != nil {
+		hookEnvs = append(hookEnvs, fmt.Sprintf("%s=%s", sqlPasswordEnv, sqlSetup.password))
+	}
+	hookEnvs = append(hookEnvs, inheritedEnv...)
+
+	if svc.Hooks.PreBuild.Commands != nil {
+		err = runScripts(serviceDir, svc.Hooks.PreBuild.Commands, hookEnvs)
+	}
 
-	builderImage := "gcr.io/buildpacks/builder:v1"
+	skipBuild := svc.Build.Skip != nil && *svc.Build.Skip == true
 
-	if appFile.Build.Buildpacks.Builder != "" {
-		skipDocker = true
-		skipJib = true
-		builderImage = appFile.Build.Buildpacks.Builder
+	builderImage := defaultBuilderImage
+	if svc.Build.Buildpacks.Builder != "" {
+		builderImage = svc.Build.Buildpacks.Builder
+	}
+	req := buildRequest{
+		project:      project,
+		region:       region,
+		dir:          serviceDir,
+		image:        image,
+		builderImage: builderImage,
+		labels:       res.labels,
+		out:          os.Stdout,
 	}
 
-	dockerFileExists, _ := dockerFileExists(appDir)
-	jibMaven, _ := jibMavenConfigured(appDir)
-	composeYamlExists, _ := composeFileExists(appDir)
+	// images built by the hooks are pushed
+	pushImage := true
+	remote := false
+	// the built image is deployed by digest when known
+	deployImage := image
 
 	if skipBuild {
 		fmt.Println(infoPrefix + " Skipping built-in build methods")
 	} else {
-		end = logProgress(fmt.Sprintf("Building container image %s", highlight(image)),
-			fmt.Sprintf("Built container image %s", highlight(image)),
-			"Failed to build container image.")
-
-		if !skipDocker && dockerFileExists {
-			fmt.Println(infoPrefix + " Attempting to build this application with its Dockerfile...")
-			fmt.Println(infoPrefix + " FYI, running the following command:")
-			cmdColor.Printf("\tdocker build -t %s %s\n", parameter(image), parameter(appDir))
-			err = dockerBuild(appDir, image)
-		} else if composeYamlExists {
-			fmt.Println(infoPrefix + " Attempting to deploy this application with Docker Compose...")
-			fmt.Println(infoPrefix + " FYI, running the following command:")
-			cmdColor.Printf("\tgcloud run compose up --region %s\n", parameter(region))
-			err = composeRunUp(appDir, region)
-			if err == nil {
-				end(true)
-				fmt.Println(successPrefix + " Your Compose application is now live on Cloud Run.\n")
-				return nil
+		settings := svc.Build
+		values := parseEnv(envs)
+		for k, v := range vars {
+			if _, ok := values[k]; !ok {
+				values[k] = v
 			}
-		} else if !skipJib && jibMaven {
-			pushImage = false
-			fmt.Println(infoPrefix + " Attempting to build this application with Jib Maven plugin...")
-			fmt.Println(infoPrefix + " FYI, running the following command:")
-			cmdColor.Printf("\tmvn package jib:build -Dimage=%s\n", parameter(image))
-			err = jibMavenBuild(appDir, image)
+		}
+		settings.Docker, err = settings.Docker.expand(func(k string) (string, bool) {
+			v, ok := values[k]
+			return v, ok
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("%w: build args can't refer to variables set once the service URL is known, disabled by their \"when\" condition or left unset", err)
+		}
+		var builder Builder
+		if settings.Strategy != "" {
+			builder, err = strategyBuilder(serviceDir, settings)
 		} else {
-			pushImage = false // --publish won't build local, so don't push anything.
-			fmt.Println(infoPrefix + " Attempting to build this application with Cloud Native Buildpacks (buildpacks.io)...")
-			fmt.Println(infoPrefix + " FYI, running the following command:")
-			cmdColor.Printf("\tpack build %s --path %s --builder %s --publish\n", parameter(image), parameter(appDir), parameter(builderImage))
-			err = packBuild(appDir, image, builderImage)
+			builder, err = detectBuilder(serviceDir, settings)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if _, ok := builder.(remoteBuilder); ok {
+			remote = true
+		} else if onCloudBuild, ok := remoteBuilderFor(builder); ok && svc.Build.Strategy == "" {
+			remote = svc.Build.Remote != nil && *svc.Build.Remote
+			if svc.Build.Remote == nil && !dockerAvailable() {
+				fmt.Println(infoPrefix + " Docker is not available, building this application on Cloud Build instead.")
+				end := logProgress("Enabling Cloud Build API...",
+					"Enabled Cloud Build API.",
+					"Failed to enable Cloud Build API.")
+				err := enableAPIs(project, remoteBuildAPIs)
+				end(err == nil)
+				if err != nil {
+					return "", "", err
+				}
+				remote = true
+			}
+			if remote {
+				builder = onCloudBuild
+			}
 		}
 
-		end(err == nil)
+		digest, err := buildWith(builder, req)
 		if err != nil {
-			return fmt.Errorf("attempted to build and failed: %s", err)
+			return "", "", fmt.Errorf("attempted to build and failed: %s", err)
+		}
+		if _, ok := builder.(composeBuilder); ok {
+			fmt.Println(successPrefix + " Your Compose application is now live on Cloud Run.\n")
+			return serviceName, "", nil
 		}
+		if digest != "" {
+			deployImage = image + "@" + digest
+		}
+		pushImage = builder.NeedsPush()
 	}
 
-	if appFile.Hooks.PostBuild.Commands != nil {
-		err = runScripts(appDir, appFile.Hooks.PostBuild.Commands, hookEnvs)
+	if svc.Hooks.PostBuild.Commands != nil {
+		err = runScripts(serviceDir, svc.Hooks.PostBuild.Commands, hookEnvs)
 	}
 
 	if pushImage {
-		fmt.Println(infoPrefix + " FYI, running the following command:")
-		cmdColor.Printf("\tdocker push %s\n", parameter(image))
-		end = logProgress("Pushing container image...",
-			"Pushed container image to Google Container Registry.",
-			"Failed to push container image to Google Container Registry.")
-		err = dockerPush(image)
-		end(err == nil)
-		if err != nil {
-			return fmt.Errorf("failed to push image to %s: %+v", image, err)
+		if err := pushBuiltImage(image); err != nil {
+			return "", "", err
 		}
 	}
 
+	for _, sc := range svc.Sidecars {
+		if sc.Dir == "" || skipBuild {
+			continue
+		}
+		sidecarDir := filepath.Join(serviceDir, sc.Dir)
+		if _, err := os.Stat(filepath.Join(sidecarDir, "Dockerfile")); err != nil {
+			return "", "", fmt.Errorf("sidecar %q has no Dockerfile in %s: %w", sc.Name, sidecarDir, err)
+		}
+		var sidecarBuilder Builder = dockerBuilder{}
+		if remote {
+			sidecarBuilder = remoteBuilder{dockerfile: true}
+		}
+		sidecarReq := req
+		sidecarReq.dir = sidecarDir
+		sidecarReq.image = sidecarImage(image, sc)
+		if _, err := buildWith(sidecarBuilder, sidecarReq); err != nil {
+			return "", "", fmt.Errorf("failed to build sidecar %q: %w", sc.Name, err)
+		}
+		if sidecarBuilder.NeedsPush() {
+			if err := pushBuiltImage(sidecarReq.image); err != nil {
+				return "", "", fmt.Errorf("failed to build sidecar %q: %w", sc.Name, err)
+			}
+		}
+	}
+	// also removes the sidecars that are no longer declared
+	applyService = append(applyService, withSidecars(image, svc.Sidecars))
+	if svc.Probes != nil {
+		applyService = append(applyService, withProbes(*svc.Probes))
+	}
+
 	if existingService == nil {
-		err = runScripts(appDir, appFile.Hooks.PreCreate.Commands, hookEnvs)
+		err = runScripts(serviceDir, svc.Hooks.PreCreate.Commands, hookEnvs)
 		if err != nil {
-			return err
+			return "", "", err
 		}
 	}
 
-	optionsFlags := optionsToFlags(appFile.Options)
+	optionsFlags := optionsToFlags(svc.Options)
+	deployCommand := "gcloud run deploy"
+	if svc.isJob() {
+		optionsFlags = jobOptionsFlags(svc.Options)
+		deployCommand = "gcloud run jobs deploy"
+	}
 
 	serviceLabel := highlight(serviceName)
 	fmt.Println(infoPrefix + " FYI, running the following command:")
-	cmdColor.Printf("\tgcloud run deploy %s", parameter(serviceName))
+	cmdColor.Printf("\t%s %s", deployCommand, parameter(serviceName))
 	cmdColor.Println("\\")
 	cmdColor.Printf("\t  --project=%s", parameter(project))
-	cmdColor.Println("\\")
-	cmdColor.Printf("\t  --platform=%s", parameter("managed"))
+	if !svc.isJob() {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --platform=%s", parameter("managed"))
+	}
 	cmdColor.Println("\\")
 	cmdColor.Printf("\t  --region=%s", parameter(region))
 	cmdColor.Println("\\")
-	cmdColor.Printf("\t  --image=%s", parameter(image))
-	if appFile.Options.Port > 0 {
+	cmdColor.Printf("\t  --image=%s", parameter(deployImage))
+	if svc.Options.Port > 0 {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --port=%s", parameter(fmt.Sprintf("%d", svc.Options.Port)))
+	}
+	if svc.ServiceAccount != nil {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --service-account=%s", parameter(runtimeAccount))
+	}
+	if sqlSetup != nil {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --add-cloudsql-instances=%s", parameter(sqlSetup.connectionName))
+	}
+	for _, volumeFlag := range volumeFlags(project, serviceName, svc.Volumes) {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  %s", volumeFlag)
+	}
+	if len(deployEnvs) > 0 {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --update-env-vars=%s", parameter(strings.Join(deployEnvs, ",")))
+	}
+	if len(secrets) > 0 {
+		var refs []string
+		for _, k := range sortedKeys(secrets) {
+			refs = append(refs, fmt.Sprintf("%s=%s:latest", k, secrets[k]))
+		}
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --update-secrets=%s", parameter(strings.Join(refs, ",")))
+	}
+	if len(removedEnvVars) > 0 {
 		cmdColor.Println("\\")
-		cmdColor.Printf("\t  --port=%s", parameter(fmt.Sprintf("%d", appFile.Options.Port)))
+		cmdColor.Printf("\t  --remove-env-vars=%s", parameter(strings.Join(removedEnvVars, ",")))
 	}
-	if len(envs) > 0 {
+	if len(removedSecrets) > 0 {
 		cmdColor.Println("\\")
-		cmdColor.Printf("\t  --update-env-vars=%s", parameter(strings.Join(envs, ",")))
+		cmdColor.Printf("\t  --remove-secrets=%s", parameter(strings.Join(removedSecrets, ",")))
 	}
 
 	for _, optionFlag := range optionsFlags {
 		cmdColor.Println("\\")
 		cmdColor.Printf("\t  %s", optionFlag)
 	}
+	cmdColor.Println("\\")
+	cmdColor.Printf("\t  %s", labelsFlag(res.labels))
+	if res.kmsKey != "" {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --key=%s", parameter(res.kmsKey))
+	}
+	if svc.Probes != nil {
+		for _, probeFlag := range probeFlags(*svc.Probes, ingressPort(svc.Options, svc.Sidecars)) {
+			cmdColor.Println("\\")
+			cmdColor.Printf("\t  %s", probeFlag)
+		}
+	}
+	if removed := removedSidecars(existingService, svc.Sidecars); len(removed) > 0 {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --remove-containers=%s", parameter(strings.Join(removed, ",")))
+	}
+	for _, sidecarFlag := range sidecarFlags(image, svc.Sidecars) {
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  %s", sidecarFlag)
+	}
+	if svc.isJob() {
+		for _, jobFlag := range jobFlags(svc.jobSettings()) {
+			cmdColor.Println("\\")
+			cmdColor.Printf("\t  %s", jobFlag)
+		}
+	}
 
 	cmdColor.Println("")
+	if svc.Options.WorkingDir != "" {
+		// gcloud has no flag for it
+		fmt.Printf("%s The container also runs in working directory %s.\n", infoPrefix, parameter(svc.Options.WorkingDir))
+	}
 
-	end = logProgress(fmt.Sprintf("Deploying service %s to Cloud Run...", serviceLabel),
+	if svc.isJob() {
+		if err := runJob(project, region, serviceName, deployImage, deployEnvs, secrets, sync, svc.Options, svc.jobSettings(), applyService); err != nil {
+			return "", "", err
+		}
+		if existingService == nil {
+			err = runScripts(serviceDir, svc.Hooks.PostCreate.Commands, hookEnvs)
+			if err != nil {
+				return "", "", err
+			}
+		}
+		return serviceName, "", nil
+	}
+
+	end := logProgress(fmt.Sprintf("Deploying service %s to Cloud Run...", serviceLabel),
 		fmt.Sprintf("Successfully deployed service %s to Cloud Run.", serviceLabel),
 		"Failed deploying the application to Cloud Run.")
-	url, err := deploy(project, serviceName, image, region, envs, appFile.Options)
+	url, err := deploy(project, serviceName, deployImage, region, deployEnvs, secrets, sync, svc.Options, applyService...)
 	end(err == nil)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	hookEnvs = append(hookEnvs, fmt.Sprintf("SERVICE_URL=%s", url))
+	hookEnvs = append(hookEnvs, fmt.Sprintf("%s=%s", serviceURLVar, url))
+
+	if len(deferredEnvs) > 0 {
+		for k, v := range parseEnv(envs) {
+			vars[k] = v
+		}
+		vars[serviceURLVar] = url
+		laterEnvs, err := generateEnvs(deferredEnvs, vars, answers)
+		if err != nil {
+			return "", "", err
+		}
+		saved.add(laterEnvs, deferredEnvs, answers)
+		laterDeployEnvs, laterSecretValues := splitSecretEnvs(laterEnvs, deferredEnvs)
+		laterSecrets, err := storeSecretEnvs(laterSecretValues)
+		if err != nil {
+			return "", "", err
+		}
+
+		fmt.Println(infoPrefix + " FYI, running the following command:")
+		cmdColor.Printf("\tgcloud run services update %s", parameter(serviceName))
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --project=%s", parameter(project))
+		cmdColor.Println("\\")
+		cmdColor.Printf("\t  --region=%s", parameter(region))
+		if len(laterDeployEnvs) > 0 {
+			cmdColor.Println("\\")
+			cmdColor.Printf("\t  --update-env-vars=%s", parameter(strings.Join(laterDeployEnvs, ",")))
+		}
+		if len(laterSecrets) > 0 {
+			var refs []string
+			for _, k := range sortedKeys(laterSecrets) {
+				refs = append(refs, fmt.Sprintf("%s=%s:latest", k, laterSecrets[k]))
+			}
+			cmdColor.Println("\\")
+			cmdColor.Printf("\t  --update-secrets=%s", parameter(strings.Join(refs, ",")))
+		}
+		cmdColor.Println("")
+
+		end := logProgress(fmt.Sprintf("Setting environment variables referring to %s on service %s...", serviceURLVar, serviceLabel),
+			fmt.Sprintf("Set environment variables referring to %s on service %s.", serviceURLVar, serviceLabel),
+			"Failed to set the environment variables referring to the service URL.")
+		_, err = deploy(project, serviceName, deployImage, region, laterDeployEnvs, laterSecrets, envSync{managed: sync.managed}, svc.Options, applyService...)
+		end(err == nil)
+		if err != nil {
+			return "", "", err
+		}
+		hookEnvs = append(hookEnvs, laterEnvs...)
+	}
 
 	if existingService == nil {
-		err = runScripts(appDir, appFile.Hooks.PostCreate.Commands, hookEnvs)
+		err = runScripts(serviceDir, svc.Hooks.PostCreate.Commands, hookEnvs)
 		if err != nil {
-			return err
+			return "", "", err
 		}
 	}
 
-	fmt.Printf("* This application is billed only when it's handling requests.\n")
-	fmt.Printf("* Manage this application at Cloud Console:\n\t")
-	color.New(color.Underline, color.Bold).Printf("https://console.cloud.google.com/run/detail/%s/%s?project=%s\n", region, serviceName, project)
-	fmt.Printf("* Learn more about Cloud Run:\n\t")
-	color.New(color.Underline, color.Bold).Println("https://cloud.google.com/run/docs")
-	fmt.Printf(successPrefix+" %s%s\n",
-		color.New(color.Bold).Sprint("Your application is now live here:\n\t"),
-		color.New(color.Bold, color.FgGreen, color.Underline).Sprint(url))
+	return serviceName, url, nil
+}
+
+// buildWith builds the image with the builder, after showing the equivalent
+// command. It returns the digest of the image if the builder knows it.
+func buildWith(b Builder, req buildRequest) (string, error) {
+	image := color.CyanString(req.image)
+	what, command := b.Describe(req.display(func(s string) string { return parameterLabel.Sprint(s) }))
+	fmt.Printf("%s Attempting to %s...\n", infoPrefix, what)
+	fmt.Println(infoPrefix + " FYI, running the following command:")
+	color.New(color.FgHiBlue).Printf("\t%s\n", command)
+
+	if _, ok := b.(remoteBuilder); ok {
+		// the build logs are shown instead of a spinner
+		digest, err := b.Build(req)
+		if err != nil {
+			fmt.Printf("%s Failed to build container image %s.\n", errorPrefix, image)
+			return "", err
+		}
+		fmt.Printf("%s Built and pushed container image %s\n", successPrefix, image)
+		return digest, nil
+	}
+	end := logProgress(fmt.Sprintf("Building container image %s", image),
+		fmt.Sprintf("Built container image %s", image),
+		"Failed to build container image.")
+	digest, err := b.Build(req)
+	end(err == nil)
+	return digest, err
+}
+
+// pushBuiltImage pushes the image with docker.
+func pushBuiltImage(image string) error {
+	fmt.Println(infoPrefix + " FYI, running the following command:")
+	color.New(color.FgHiBlue).Printf("\tdocker push %s\n", parameterLabel.Sprint(image))
+	end := logProgress("Pushing container image...",
+		"Pushed container image to Google Container Registry.",
+		"Failed to push container image to Google Container Registry.")
+	err := dockerPush(image)
+	end(err == nil)
+	if err != nil {
+		return fmt.Errorf("failed to push image to %s: %+v", image, err)
+	}
+	return nil
+}
+
+// runJob deploys the job, and runs it once if its settings ask for it,
+// reporting the status of the execution.
+func runJob(project, region, name, image string, envs []string, secrets map[string]string, sync envSync, options options, settings job, apply []func(*runapi.Service)) error {
+	jobLabel := color.CyanString(name)
+	end := logProgress(fmt.Sprintf("Deploying job %s to Cloud Run...", jobLabel),
+		fmt.Sprintf("Successfully deployed job %s to Cloud Run.", jobLabel),
+		"Failed deploying the job to Cloud Run.")
+	err := deployJob(project, name, image, region, envs, secrets, sync, options, settings, apply...)
+	end(err == nil)
+	if err != nil {
+		return err
+	}
+	if !settings.Execute {
+		return nil
+	}
+
+	end = logProgress(fmt.Sprintf("Running job %s...", jobLabel),
+		"",
+		"Failed to run the job.")
+	exec, err := executeJob(project, name, region)
+	end(err == nil)
+	if err != nil {
+		return err
+	}
+	result, ok := executionResult(exec)
+	if ok {
+		fmt.Printf("%s Execution %s completed: %s\n", successPrefix, color.CyanString(exec.Metadata.Name), result)
+	} else {
+		fmt.Printf("%s Execution %s failed: %s\n", errorPrefix, color.CyanString(exec.Metadata.Name), result)
+	}
+	if exec.Status != nil && exec.Status.LogUri != "" {
+		fmt.Printf("%s See its logs at:\n\t%s\n", infoPrefix, linkLabel.Sprint(exec.Status.LogUri))
+	}
+	return nil
+}
+
+// confirmRemoveEnvs shows the environment variables that are no longer declared
+// in app.json and asks for confirmation before removing them from the service.
+func confirmRemoveEnvs(names []string) (bool, error) {
+	if len(names) == 0 {
+		return false, nil
+	}
+	fmt.Println(infoPrefix + " The following environment variables are no longer declared in app.json:")
+	for _, k := range names {
+		fmt.Printf("\t%s\n", color.CyanString(k))
+	}
+	ok := true
+	if err := survey.AskOne(&survey.Confirm{
+		Message: "Remove them from the service?",
+		Default: true,
+	}, &ok, surveyIconOpts); err != nil {
+		return false, fmt.Errorf("could not prompt for confirmation to remove environment variables: %+v", err)
+	}
+	return ok, nil
+}
+
+// promptSaveEnvFile offers to save the values of the environment variables in
+// a .env file, to use with --env_file on the next deployments.
+func promptSaveEnvFile(path string, saved *savedEnvs) error {
+	if len(saved.values) == 0 {
+		return nil
+	}
+	save := false
+	if err := survey.AskOne(&survey.Confirm{
+		Message: "Save the values of the environment variables to a .env file for the next deployments?",
+		Default: false,
+	}, &save, surveyIconOpts); err != nil {
+		return fmt.Errorf("could not prompt for saving the environment variables: %+v", err)
+	}
+	if !save {
+		return nil
+	}
+	if err := survey.AskOne(&survey.Input{
+		Message: "Path of the .env file:",
+		Default: path,
+	}, &path, survey.WithValidator(survey.Required), surveyIconOpts); err != nil {
+		return fmt.Errorf("could not prompt for the path of the .env file: %+v", err)
+	}
+	withSecrets := false
+	if n := saved.numSecrets(); n > 0 {
+		if err := survey.AskOne(&survey.Confirm{
+			Message: fmt.Sprintf("Also save the %d generated secret values? They are stored in plain text.", n),
+			Default: false,
+		}, &withSecrets, surveyIconOpts); err != nil {
+			return fmt.Errorf("could not prompt for saving the generated secrets: %+v", err)
+		}
+	}
+	if err := writeDotEnv(path, saved.toSave(withSecrets)); err != nil {
+		return err
+	}
+	fmt.Printf("%s Saved the environment variables to %s\n", successPrefix, color.CyanString(path))
 	return nil
 }
 
@@ -526,9 +1130,85 @@ func optionsToFlags(options options) []string {
 		flags = append(flags, maxInstancesSetting)
 	}
 
+	if options.MinInstances != nil {
+		minInstancesSetting := fmt.Sprintf("--min-instances=%d", *options.MinInstances)
+		flags = append(flags, minInstancesSetting)
+	}
+
+	if options.Timeout > 0 {
+		timeoutSetting := fmt.Sprintf("--timeout=%d", options.Timeout)
+		flags = append(flags, timeoutSetting)
+	}
+
+	if options.CPUBoost != nil {
+		if *options.CPUBoost == false {
+			flags = append(flags, "--no-cpu-boost")
+		} else {
+			flags = append(flags, "--cpu-boost")
+		}
+	}
+
+	if options.CPUThrottling != nil {
+		if *options.CPUThrottling == false {
+			flags = append(flags, "--no-cpu-throttling")
+		} else {
+			flags = append(flags, "--cpu-throttling")
+		}
+	}
+
+	if options.Ingress != "" {
+		flags = append(flags, fmt.Sprintf("--ingress=%s", options.Ingress))
+	}
+
+	if options.VPCConnector != "" {
+		flags = append(flags, fmt.Sprintf("--vpc-connector=%s", options.VPCConnector))
+	}
+
+	if options.Network != "" {
+		flags = append(flags, fmt.Sprintf("--network=%s", options.Network))
+	}
+
+	if options.Subnet != "" {
+		flags = append(flags, fmt.Sprintf("--subnet=%s", options.Subnet))
+	}
+
+	if len(options.NetworkTags) > 0 {
+		flags = append(flags, fmt.Sprintf("--network-tags=%s", strings.Join(options.NetworkTags, ",")))
+	}
+
+	if options.VPCEgress != "" {
+		flags = append(flags, fmt.Sprintf("--vpc-egress=%s", options.VPCEgress))
+	}
+
+	if len(options.Command) > 0 {
+		flags = append(flags, listFlag("--command", options.Command))
+	}
+
+	if len(options.Args) > 0 {
+		flags = append(flags, listFlag("--args", options.Args))
+	}
+
 	return flags
 }
 
+// listFlag returns the gcloud flag setting the list of values. The values are
+// separated by commas, or by another delimiter if one of them has a comma (see
+// "gcloud topic escaping").
+func listFlag(name string, values []string) string {
+	joined := strings.Join(values, "")
+	if !strings.Contains(joined, ",") {
+		return fmt.Sprintf("%s=%s", name, strings.Join(values, ","))
+	}
+	delim := "|"
+	for _, d := range []string{"|", ";", "~", "#"} {
+		if !strings.Contains(joined, d) {
+			delim = d
+			break
+		}
+	}
+	return fmt.Sprintf("%s=^%s^%s", name, delim, strings.Join(values, delim))
+}
+
 // waitCredsAvailable polls until Cloud Shell VM has available credentials.
 // Credentials might be missing in the environment for some GSuite users that
 // need to authenticate every N hours. See internal bug 154573156 for details.
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"regexp"
 	"testing"
 	"time"
@@ -177,3 +178,37 @@ func withTestEnv(k, v string) (cleanup func()) {
 		os.Setenv(k, orig)
 	}
 }
+
+func Test_optionsToFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		options options
+		want    []string
+	}{
+		{"defaults", options{}, []string{"--allow-unauthenticated"}},
+		{"scaling", options{MinInstances: &one, MaxInstances: 5},
+			[]string{"--allow-unauthenticated", "--max-instances=5", "--min-instances=1"}},
+		{"zero min-instances", options{MinInstances: &zero},
+			[]string{"--allow-unauthenticated", "--min-instances=0"}},
+		{"timeout", options{Timeout: 900}, []string{"--allow-unauthenticated", "--timeout=900"}},
+		{"cpu enabled", options{CPUBoost: &tru, CPUThrottling: &tru},
+			[]string{"--allow-unauthenticated", "--cpu-boost", "--cpu-throttling"}},
+		{"cpu disabled", options{CPUBoost: &fals, CPUThrottling: &fals},
+			[]string{"--allow-unauthenticated", "--no-cpu-boost", "--no-cpu-throttling"}},
+		{"vpc connector", options{Ingress: "internal", VPCConnector: "c", VPCEgress: "all-traffic"},
+			[]string{"--allow-unauthenticated", "--ingress=internal", "--vpc-connector=c", "--vpc-egress=all-traffic"}},
+		{"direct vpc", options{Network: "n", Subnet: "s", NetworkTags: []string{"a", "b"}},
+			[]string{"--allow-unauthenticated", "--network=n", "--subnet=s", "--network-tags=a,b"}},
+		{"command and args", options{Command: []string{"gunicorn"}, Args: []string{"--bind", ":8080", "app:app"}, WorkingDir: "/srv"},
+			[]string{"--allow-unauthenticated", "--command=gunicorn", "--args=--bind,:8080,app:app"}},
+		{"args with commas", options{Args: []string{"-c", "print(1, 2)"}},
+			[]string{"--allow-unauthenticated", "--args=^|^-c|print(1, 2)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := optionsToFlags(tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("optionsToFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
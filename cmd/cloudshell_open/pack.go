@@ -27,3 +27,28 @@ func packBuild(dir, image, builderImage string) error {
 	}
 	return nil
 }
+
+// packBuilder builds and publishes the image with Cloud Native Buildpacks,
+// which apply to any source.
+type packBuilder struct{}
+
+func (packBuilder) Detect(dir string) (bool, error) { return true, nil }
+
+func (packBuilder) Check(dir string) error {
+	if _, err := exec.LookPath("pack"); err != nil {
+		return fmt.Errorf("pack is not installed")
+	}
+	return nil
+}
+
+func (packBuilder) Describe(req buildRequest) (string, string) {
+	return "build this application with Cloud Native Buildpacks (buildpacks.io)",
+		fmt.Sprintf("pack build %s --path %s --builder %s --publish", req.image, req.dir, req.builderImage)
+}
+
+func (packBuilder) Build(req buildRequest) (string, error) {
+	return "", packBuild(req.dir, req.image, req.builderImage)
+}
+
+// NeedsPush is false as --publish pushes the image instead of building it locally.
+func (packBuilder) NeedsPush() bool { return false }
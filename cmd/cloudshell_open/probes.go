@@ -0,0 +1,241 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+// probes configures the health checks of the container of the service.
+type probes struct {
+	Startup  *probe `json:"startup"`
+	Liveness *probe `json:"liveness"`
+}
+
+// probe is a health check of the container. Durations are in seconds, and
+// zero values use the defaults of Cloud Run.
+type probe struct {
+	// Type is "http", "grpc" or "tcp". It defaults to "http" if Path is set,
+	// "tcp" otherwise.
+	Type string `json:"type"`
+	// Path is the path of HTTP probes.
+	Path string `json:"path"`
+	// Service is the service name of gRPC probes.
+	Service string `json:"service"`
+	// Port defaults to the port of the container.
+	Port             int `json:"port"`
+	InitialDelay     int `json:"initial-delay"`
+	Period           int `json:"period"`
+	Timeout          int `json:"timeout"`
+	FailureThreshold int `json:"failure-threshold"`
+}
+
+const (
+	probeHTTP = "http"
+	probeGRPC = "grpc"
+	probeTCP  = "tcp"
+
+	// maxProbeDelay is the maximum initial delay, period and timeout of
+	// startup probes, in seconds.
+	maxProbeDelay = 240
+	// maxLivenessPeriod is the maximum period of liveness probes, in seconds.
+	maxLivenessPeriod = 3600
+)
+
+// probeType returns the type of the probe, applying the default.
+func (p probe) probeType() string {
+	if p.Type != "" {
+		return p.Type
+	}
+	if p.Path != "" {
+		return probeHTTP
+	}
+	return probeTCP
+}
+
+// validate checks the probes.
+func (p probes) validate() error {
+	if p.Startup != nil {
+		if err := p.Startup.validate(maxProbeDelay); err != nil {
+			return fmt.Errorf("invalid startup probe: %w", err)
+		}
+	}
+	if p.Liveness != nil {
+		if p.Liveness.probeType() == probeTCP {
+			return fmt.Errorf("invalid liveness probe: must be an http or grpc probe")
+		}
+		if err := p.Liveness.validate(maxLivenessPeriod); err != nil {
+			return fmt.Errorf("invalid liveness probe: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p probe) validate(maxPeriod int) error {
+	switch p.probeType() {
+	case probeHTTP:
+		if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("path must start with /")
+		}
+	case probeGRPC, probeTCP:
+		if p.Path != "" {
+			return fmt.Errorf("path can only be set on http probes")
+		}
+	default:
+		return fmt.Errorf("unknown type %q, must be one of: %s, %s, %s", p.Type, probeHTTP, probeGRPC, probeTCP)
+	}
+	if p.Service != "" && p.probeType() != probeGRPC {
+		return fmt.Errorf("service can only be set on grpc probes")
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid port %d", p.Port)
+	}
+	if p.InitialDelay < 0 || p.InitialDelay > maxProbeDelay {
+		return fmt.Errorf("initial-delay must be between 0 and %d seconds", maxProbeDelay)
+	}
+	if p.Period < 0 || p.Period > maxPeriod {
+		return fmt.Errorf("period must be between 1 and %d seconds", maxPeriod)
+	}
+	if p.Timeout < 0 || p.Timeout > maxProbeDelay {
+		return fmt.Errorf("timeout must be between 1 and %d seconds", maxProbeDelay)
+	}
+	if p.Timeout > 0 && p.Period > 0 && p.Timeout > p.Period {
+		return fmt.Errorf("timeout must not be greater than period")
+	}
+	if p.FailureThreshold < 0 {
+		return fmt.Errorf("failure-threshold must not be negative")
+	}
+	return nil
+}
+
+// probePort returns the port of the probe, which defaults to port, the port
+// of the container.
+func (p probe) probePort(port int) int {
+	if p.Port > 0 {
+		return p.Port
+	}
+	return port
+}
+
+// runProbe returns the probe of the container, where port is the port of the
+// container.
+func (p probe) runProbe(port int) *runapi.Probe {
+	port = p.probePort(port)
+	out := &runapi.Probe{
+		InitialDelaySeconds: int64(p.InitialDelay),
+		PeriodSeconds:       int64(p.Period),
+		TimeoutSeconds:      int64(p.Timeout),
+		FailureThreshold:    int64(p.FailureThreshold),
+	}
+	switch p.probeType() {
+	case probeHTTP:
+		out.HttpGet = &runapi.HTTPGetAction{Path: p.Path, Port: int64(port)}
+	case probeGRPC:
+		out.Grpc = &runapi.GRPCAction{Service: p.Service, Port: int64(port)}
+	case probeTCP:
+		out.TcpSocket = &runapi.TCPSocketAction{Port: int64(port)}
+	}
+	return out
+}
+
+// withProbes sets the declared probes of the container of the Service. Their
+// port defaults to the one of the container receiving the requests, so it
+// must be applied after withSidecars.
+func withProbes(p probes) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		c := mainContainer(svc)
+		port := ingressContainerPort(svc)
+		if p.Startup != nil {
+			c.StartupProbe = p.Startup.runProbe(port)
+		}
+		if p.Liveness != nil {
+			c.LivenessProbe = p.Liveness.runProbe(port)
+		}
+	}
+}
+
+// readyTimeout returns how long to wait for the Service to become ready, which
+// is longer for containers whose startup probe allows a slow start.
+func readyTimeout(svc *runapi.Service) time.Duration {
+	wait := time.Minute * 4
+	p := mainContainer(svc).StartupProbe
+	if p == nil {
+		return wait
+	}
+	// defaults of Cloud Run
+	period, failures := p.PeriodSeconds, p.FailureThreshold
+	if period == 0 {
+		period = 10
+	}
+	if failures == 0 {
+		failures = 3
+	}
+	startup := time.Duration(p.InitialDelaySeconds+period*failures)*time.Second + time.Minute
+	if startup > wait {
+		return startup
+	}
+	return wait
+}
+
+// probeFlags returns the gcloud flags setting the probes, where port is the
+// port of the container.
+func probeFlags(p probes, port int) []string {
+	var flags []string
+	if p.Startup != nil {
+		flags = append(flags, "--startup-probe="+p.Startup.flagValue(port))
+	}
+	if p.Liveness != nil {
+		flags = append(flags, "--liveness-probe="+p.Liveness.flagValue(port))
+	}
+	return flags
+}
+
+// flagValue returns the KEY=VALUE settings of the probe for gcloud, where
+// port is the port of the container.
+func (p probe) flagValue(port int) string {
+	port = p.probePort(port)
+	var settings []string
+	add := func(k string, v int) {
+		if v > 0 {
+			settings = append(settings, k+"="+strconv.Itoa(v))
+		}
+	}
+	switch p.probeType() {
+	case probeHTTP:
+		path := p.Path
+		if path == "" {
+			path = "/"
+		}
+		settings = append(settings, "httpGet.path="+path)
+		add("httpGet.port", port)
+	case probeGRPC:
+		add("grpc.port", port)
+		if p.Service != "" {
+			settings = append(settings, "grpc.service="+p.Service)
+		}
+	case probeTCP:
+		add("tcpSocket.port", port)
+	}
+	add("initialDelaySeconds", p.InitialDelay)
+	add("periodSeconds", p.Period)
+	add("timeoutSeconds", p.Timeout)
+	add("failureThreshold", p.FailureThreshold)
+	return strings.Join(settings, ",")
+}
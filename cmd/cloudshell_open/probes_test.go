@@ -0,0 +1,103 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+func Test_probes_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       probes
+		wantErr bool
+	}{
+		{"empty", probes{}, false},
+		{"http startup", probes{Startup: &probe{Path: "/healthz", InitialDelay: 30, Period: 10, Timeout: 5, FailureThreshold: 30}}, false},
+		{"tcp startup", probes{Startup: &probe{Type: "tcp", Port: 8080}}, false},
+		{"grpc liveness", probes{Liveness: &probe{Type: "grpc", Service: "health", Period: 600}}, false},
+		{"tcp liveness", probes{Liveness: &probe{}}, true},
+		{"unknown type", probes{Startup: &probe{Type: "exec"}}, true},
+		{"relative path", probes{Startup: &probe{Path: "healthz"}}, true},
+		{"path on grpc probe", probes{Startup: &probe{Type: "grpc", Path: "/healthz"}}, true},
+		{"service on http probe", probes{Startup: &probe{Path: "/", Service: "health"}}, true},
+		{"startup period too long", probes{Startup: &probe{Period: 600}}, true},
+		{"initial delay too long", probes{Liveness: &probe{Path: "/", InitialDelay: 300}}, true},
+		{"timeout greater than period", probes{Startup: &probe{Period: 5, Timeout: 10}}, true},
+		{"negative failure threshold", probes{Startup: &probe{FailureThreshold: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_withProbes(t *testing.T) {
+	p := probes{
+		Startup:  &probe{Path: "/ready", Period: 5, FailureThreshold: 60},
+		Liveness: &probe{Type: "grpc", Service: "health"},
+	}
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{}, withProbes(p))
+	c := mainContainer(svc)
+	if expected := (&runapi.Probe{HttpGet: &runapi.HTTPGetAction{Path: "/ready", Port: 8080}, PeriodSeconds: 5, FailureThreshold: 60}); !reflect.DeepEqual(c.StartupProbe, expected) {
+		t.Fatalf("startup probe = %#v", c.StartupProbe)
+	}
+	if expected := (&runapi.Probe{Grpc: &runapi.GRPCAction{Service: "health", Port: 8080}}); !reflect.DeepEqual(c.LivenessProbe, expected) {
+		t.Fatalf("liveness probe = %#v", c.LivenessProbe)
+	}
+	if got := readyTimeout(svc); got != 6*time.Minute {
+		t.Fatalf("readyTimeout() = %v, want 6m", got)
+	}
+
+	// undeclared probes are kept
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{}, withProbes(probes{Startup: &probe{Type: "tcp"}}))
+	c = mainContainer(svc)
+	if c.StartupProbe.TcpSocket == nil || c.StartupProbe.HttpGet != nil {
+		t.Fatalf("startup probe not replaced: %#v", c.StartupProbe)
+	}
+	if c.LivenessProbe == nil {
+		t.Fatal("liveness probe removed")
+	}
+	if got := readyTimeout(svc); got != 4*time.Minute {
+		t.Fatalf("readyTimeout() = %v, want 4m", got)
+	}
+}
+
+func Test_probeFlags(t *testing.T) {
+	p := probes{
+		Startup:  &probe{Type: "tcp", InitialDelay: 10, FailureThreshold: 30},
+		Liveness: &probe{Path: "/healthz", Port: 8081, Period: 30, Timeout: 5},
+	}
+	expected := []string{
+		"--startup-probe=tcpSocket.port=8080,initialDelaySeconds=10,failureThreshold=30",
+		"--liveness-probe=httpGet.path=/healthz,httpGet.port=8081,periodSeconds=30,timeoutSeconds=5",
+	}
+	if got := probeFlags(p, 8080); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("probeFlags() = %v, want %v", got, expected)
+	}
+
+	p = probes{Liveness: &probe{Path: "/healthz"}}
+	expected = []string{"--liveness-probe=httpGet.path=/healthz,httpGet.port=8080"}
+	if got := probeFlags(p, 8080); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("probeFlags() = %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+const secretAccessorRole = "roles/secretmanager.secretAccessor"
+
+// secretName returns the ID of the Secret Manager secret that holds the value
+// of the environment variable of the specified service.
+func secretName(service, env string) string {
+	return service + "-" + strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// ensureSecret creates the secret with the labels and encryption key of the
+// resources if it doesn't already exist, and stores the value as its latest
+// version.
+func ensureSecret(project, name, value string, res resourceConfig) error {
+	client, err := secretmanager.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Secret Manager client: %w", err)
+	}
+
+	secret := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+	if _, err := client.Projects.Secrets.Get(secret).Do(); err != nil {
+		var e *googleapi.Error
+		if !errors.As(err, &e) || e.Code != http.StatusNotFound {
+			return fmt.Errorf("failed to get secret %s: %w", name, err)
+		}
+		if _, err := client.Projects.Secrets.Create("projects/"+project, &secretmanager.Secret{
+			Replication: secretReplication(res.kmsKey),
+			Labels:      res.labels,
+		}).SecretId(name).Do(); err != nil {
+			return fmt.Errorf("failed to create secret %s: %w", name, err)
+		}
+	}
+
+	if _, err := client.Projects.Secrets.AddVersion(secret, &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(value))},
+	}).Do(); err != nil {
+		return fmt.Errorf("failed to add a version to secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// accessSecret returns the value of the latest version of the secret.
+func accessSecret(project, name string) (string, error) {
+	client, err := secretmanager.NewService(context.TODO())
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize Secret Manager client: %w", err)
+	}
+
+	resp, err := client.Projects.Secrets.Versions.Access(fmt.Sprintf("projects/%s/secrets/%s/versions/latest", project, name)).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret %s: %w", name, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret %s: %w", name, err)
+	}
+	return string(b), nil
+}
+
+// grantSecretAccess allows the member to access the versions of the secret,
+// retrying while a service account that was just created is not known yet.
+func grantSecretAccess(project, name, member string) error {
+	return retryIAMPropagation(func() error { return setSecretAccess(project, name, member) })
+}
+
+func setSecretAccess(project, name, member string) error {
+	client, err := secretmanager.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Secret Manager client: %w", err)
+	}
+
+	secret := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+	policy, err := client.Projects.Secrets.GetIamPolicy(secret).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get IAM policy for secret %s: %w", name, err)
+	}
+	for _, b := range policy.Bindings {
+		if b.Role != secretAccessorRole {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == member {
+				return nil
+			}
+		}
+	}
+	policy.Bindings = append(policy.Bindings, &secretmanager.Binding{
+		Members: []string{member},
+		Role:    secretAccessorRole,
+	})
+	if _, err := client.Projects.Secrets.SetIamPolicy(secret, &secretmanager.SetIamPolicyRequest{Policy: policy}).Do(); err != nil {
+		return fmt.Errorf("failed to set IAM policy for secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// storeSecrets stores the values in Secret Manager secrets of the service,
+// created with the settings of the resources, and lets the runtime service account of the service
+// access them. An empty runtimeAccount means the default service account. It
+// returns the names of the secrets keyed by environment variable name.
+func storeSecrets(project, service, runtimeAccount string, values map[string]string, res resourceConfig) (map[string]string, error) {
+	if runtimeAccount == "" {
+		var err error
+		runtimeAccount, err = defaultServiceAccount(project)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(map[string]string)
+	for _, k := range sortedKeys(values) {
+		name := secretName(service, k)
+		if err := ensureSecret(project, name, values[k], res); err != nil {
+			return nil, err
+		}
+		if err := grantSecretAccess(project, name, "serviceAccount:"+runtimeAccount); err != nil {
+			return nil, err
+		}
+		out[k] = name
+	}
+	return out, nil
+}
+
+// sortedKeys returns the keys of the map in increasing order.
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// defaultServiceAccount returns the email of the Compute Engine default service
+// account, which Cloud Run services run as unless configured otherwise.
+func defaultServiceAccount(project string) (string, error) {
+	number, err := projectNumber(project)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-compute@developer.gserviceaccount.com", number), nil
+}
+
+// projectNumber returns the number of the project.
+func projectNumber(project string) (int64, error) {
+	client, err := cloudresourcemanager.NewService(context.TODO())
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize cloudresourcemanager client: %w", err)
+	}
+	p, err := client.Projects.Get(project).Do()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get project %s: %w", project, err)
+	}
+	return p.ProjectNumber, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+func Test_secretName(t *testing.T) {
+	tests := []struct {
+		service string
+		env     string
+		want    string
+	}{
+		{"my-svc", "DB_PASSWORD", "my-svc-db-password"},
+		{"svc", "TOKEN", "svc-token"},
+		{"svc", "API_KEY_2", "svc-api-key-2"},
+	}
+	for _, tt := range tests {
+		if got := secretName(tt.service, tt.env); got != tt.want {
+			t.Errorf("secretName(%q, %q) = %s, want %s", tt.service, tt.env, got, tt.want)
+		}
+	}
+}
+
+func Test_splitSecretEnvs(t *testing.T) {
+	tests := []struct {
+		name       string
+		envs       []string
+		list       map[string]env
+		wantPlain  []string
+		wantSecret map[string]string
+	}{
+		{"no secrets",
+			[]string{"A=1", "B=2"}, map[string]env{"A": {}, "B": {}},
+			[]string{"A=1", "B=2"}, map[string]string{}},
+		{"secret value with '='",
+			[]string{"A=1", "TOKEN=x=y", "C=3"}, map[string]env{"A": {}, "TOKEN": {Secret: true}},
+			[]string{"A=1", "C=3"}, map[string]string{"TOKEN": "x=y"}},
+		{"empty secret value",
+			[]string{"TOKEN="}, map[string]env{"TOKEN": {Secret: true}},
+			nil, map[string]string{"TOKEN": ""}},
+		{"undeclared vars stay plain",
+			[]string{"DB_PASS=p", "API_URL=u"}, map[string]env{"TOKEN": {Secret: true}},
+			[]string{"DB_PASS=p", "API_URL=u"}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, secret := splitSecretEnvs(tt.envs, tt.list)
+			if !reflect.DeepEqual(plain, tt.wantPlain) {
+				t.Errorf("splitSecretEnvs() plain = %v, want %v", plain, tt.wantPlain)
+			}
+			if !reflect.DeepEqual(secret, tt.wantSecret) {
+				t.Errorf("splitSecretEnvs() secret = %v, want %v", secret, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func Test_mergeEnvs_secretRefs(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []*runapi.EnvVar
+		env      map[string]string
+		secrets  map[string]string
+		expected []*runapi.EnvVar
+	}{
+		{"secret ref kept when not updated",
+			[]*runapi.EnvVar{secretEnvVar("TOKEN", "svc-token"), {Name: "A", Value: "1"}},
+			map[string]string{"A": "2"}, nil,
+			[]*runapi.EnvVar{{Name: "A", Value: "2"}, secretEnvVar("TOKEN", "svc-token")}},
+		{"secret ref not replaced by a plain value",
+			[]*runapi.EnvVar{secretEnvVar("TOKEN", "svc-token")},
+			map[string]string{"TOKEN": "svc-token:latest"}, nil,
+			[]*runapi.EnvVar{secretEnvVar("TOKEN", "svc-token")}},
+		{"secret ref updated to another secret",
+			[]*runapi.EnvVar{secretEnvVar("TOKEN", "old-token")},
+			nil, map[string]string{"TOKEN": "svc-token"},
+			[]*runapi.EnvVar{secretEnvVar("TOKEN", "svc-token")}},
+		{"plain value moved to a secret",
+			[]*runapi.EnvVar{{Name: "TOKEN", Value: "plain"}},
+			nil, map[string]string{"TOKEN": "svc-token"},
+			[]*runapi.EnvVar{secretEnvVar("TOKEN", "svc-token")}},
+		{"new secret added",
+			nil,
+			map[string]string{"A": "1"}, map[string]string{"TOKEN": "svc-token"},
+			[]*runapi.EnvVar{{Name: "A", Value: "1"}, secretEnvVar("TOKEN", "svc-token")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := make(map[string]string)
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			got := mergeEnvs(tt.existing, env, tt.secrets)
+			sortEnvVars(got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("mergeEnvs() = %s, want %s", envVarsString(got), envVarsString(tt.expected))
+			}
+		})
+	}
+}
+
+func Test_serviceEnvs_secretRefs(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      *runapi.EnvVar
+		expected string
+	}{
+		{"latest version", secretEnvVar("TOKEN", "svc-token"), "svc-token:latest"},
+		{"pinned version", &runapi.EnvVar{Name: "TOKEN", ValueFrom: &runapi.EnvVarSource{
+			SecretKeyRef: &runapi.SecretKeySelector{Name: "svc-token", Key: "3"}}}, "svc-token:3"},
+		{"plain value", &runapi.EnvVar{Name: "TOKEN", Value: "svc-token:latest"}, "svc-token:latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &runapi.Service{Spec: &runapi.ServiceSpec{Template: &runapi.RevisionTemplate{Spec: &runapi.RevisionSpec{
+				Containers: []*runapi.Container{{Env: []*runapi.EnvVar{tt.env}}},
+			}}}}
+			if got := serviceEnvs(svc); !reflect.DeepEqual(got, map[string]string{"TOKEN": tt.expected}) {
+				t.Errorf("serviceEnvs() = %v, want TOKEN=%s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+)
+
+// serviceAccount configures the service account the service runs as.
+type serviceAccount struct {
+	// Name is the account ID or email of an existing service account to use
+	// instead of creating one for the service.
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+var (
+	serviceAccountIDPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	rolePattern             = regexp.MustCompile(`^(roles|(projects|organizations)/[^/]+/roles)/[A-Za-z0-9_.]+$`)
+)
+
+// validate checks the name and the roles of the service account.
+func (s serviceAccount) validate() error {
+	if s.Name != "" && !strings.Contains(s.Name, "@") && !serviceAccountIDPattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid service account name %q, must be an email or 6-30 lowercase letters, digits and hyphens", s.Name)
+	}
+	for _, r := range s.Roles {
+		if !rolePattern.MatchString(r) {
+			return fmt.Errorf("invalid role %q, must be like roles/datastore.user", r)
+		}
+	}
+	return nil
+}
+
+// serviceAccountID returns the ID of the service account created for the
+// Cloud Run service. Long names are truncated with a hash of the name, so
+// that services sharing a prefix get distinct accounts.
+func serviceAccountID(service string) string {
+	id := service
+	if len(id) > 27 {
+		sum := sha256.Sum256([]byte(service))
+		id = strings.TrimRight(id[:20], "-") + "-" + hex.EncodeToString(sum[:])[:6]
+	}
+	id += "-sa"
+	if len(id) < 6 {
+		id = "run-" + id
+	}
+	return id
+}
+
+// serviceAccountEmail returns the email of the service account in the project
+// with the specified ID, or the email itself.
+func serviceAccountEmail(project, idOrEmail string) string {
+	if strings.Contains(idOrEmail, "@") {
+		return idOrEmail
+	}
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", idOrEmail, project)
+}
+
+// setupServiceAccount creates the service account of the Cloud Run service if
+// it doesn't reuse an existing one, and grants it its roles. It returns the
+// email of the service account and the roles it has on the project besides
+// the declared ones.
+func setupServiceAccount(project, service string, sa serviceAccount) (string, []string, error) {
+	var email string
+	if sa.Name != "" {
+		email = serviceAccountEmail(project, sa.Name)
+		if err := getServiceAccount(email); err != nil {
+			return "", nil, err
+		}
+	} else {
+		var err error
+		email, err = ensureServiceAccount(project, serviceAccountID(service), "Cloud Run service "+service)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	var roles []string
+	err := retryIAMPropagation(func() error {
+		var err error
+		roles, err = grantProjectRoles(project, "serviceAccount:"+email, sa.Roles)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return email, undeclaredRoles(roles, sa.Roles), nil
+}
+
+func getServiceAccount(email string) error {
+	client, err := iam.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize IAM client: %w", err)
+	}
+	if _, err := client.Projects.ServiceAccounts.Get("projects/-/serviceAccounts/" + email).Do(); err != nil {
+		return fmt.Errorf("failed to get service account %s: %w", email, err)
+	}
+	return nil
+}
+
+// ensureServiceAccount creates the service account if it doesn't already
+// exist, and returns its email.
+func ensureServiceAccount(project, id, displayName string) (string, error) {
+	client, err := iam.NewService(context.TODO())
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize IAM client: %w", err)
+	}
+
+	email := serviceAccountEmail(project, id)
+	_, err = client.Projects.ServiceAccounts.Get(fmt.Sprintf("projects/%s/serviceAccounts/%s", project, email)).Do()
+	if err == nil {
+		return email, nil
+	}
+	var e *googleapi.Error
+	if !errors.As(err, &e) || e.Code != http.StatusNotFound {
+		return "", fmt.Errorf("failed to get service account %s: %w", email, err)
+	}
+	sa, err := client.Projects.ServiceAccounts.Create("projects/"+project, &iam.CreateServiceAccountRequest{
+		AccountId:      id,
+		ServiceAccount: &iam.ServiceAccount{DisplayName: displayName},
+	}).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to create service account %s: %w", email, err)
+	}
+	return sa.Email, nil
+}
+
+// grantProjectRoles grants the roles on the project to the member unless it
+// already has them, and returns all the roles of the member on the project.
+func grantProjectRoles(project, member string, roles []string) ([]string, error) {
+	client, err := cloudresourcemanager.NewService(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cloudresourcemanager client: %w", err)
+	}
+
+	policy, err := client.Projects.GetIamPolicy(project, &cloudresourcemanager.GetIamPolicyRequest{
+		Options: &cloudresourcemanager.GetPolicyOptions{RequestedPolicyVersion: 3},
+	}).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IAM policy of project %s: %w", project, err)
+	}
+	if granted := addRoleBindings(policy, member, roles); len(granted) > 0 {
+		policy.Version = 3
+		if _, err := client.Projects.SetIamPolicy(project, &cloudresourcemanager.SetIamPolicyRequest{
+			Policy: policy,
+		}).Do(); err != nil {
+			return nil, fmt.Errorf("failed to grant %s to %s: %w", strings.Join(granted, ", "), member, err)
+		}
+	}
+	return memberRoles(policy, member), nil
+}
+
+// iamPropagationTimeout is how long IAM policy changes are retried while a
+// service account that was just created is not known yet.
+const iamPropagationTimeout = 2 * time.Minute
+
+// retryIAMPropagation runs f again while it fails because of IAM propagation
+// delays or concurrent policy changes, at most for iamPropagationTimeout.
+func retryIAMPropagation(f func() error) error {
+	deadline := time.Now().Add(iamPropagationTimeout)
+	wait := time.Second
+	for {
+		err := f()
+		if err == nil || !isIAMPropagationError(err) || time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		time.Sleep(wait)
+		if wait < 16*time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// isIAMPropagationError checks if the error is about a member of an IAM
+// policy that doesn't exist yet, or about a concurrent change of the policy.
+func isIAMPropagationError(err error) bool {
+	var e *googleapi.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Code == http.StatusConflict ||
+		(e.Code == http.StatusBadRequest && strings.Contains(e.Message, "does not exist"))
+}
+
+// addRoleBindings adds the roles the member doesn't already have to the
+// policy, and returns them. Conditional bindings don't count as having a role.
+func addRoleBindings(policy *cloudresourcemanager.Policy, member string, roles []string) []string {
+	has := make(map[string]bool)
+	for _, r := range memberRoles(policy, member) {
+		has[r] = true
+	}
+	var granted []string
+	for _, role := range roles {
+		if has[role] {
+			continue
+		}
+		has[role] = true
+		granted = append(granted, role)
+
+		var binding *cloudresourcemanager.Binding
+		for _, b := range policy.Bindings {
+			if b.Role == role && b.Condition == nil {
+				binding = b
+				break
+			}
+		}
+		if binding == nil {
+			binding = &cloudresourcemanager.Binding{Role: role}
+			policy.Bindings = append(policy.Bindings, binding)
+		}
+		binding.Members = append(binding.Members, member)
+	}
+	return granted
+}
+
+// memberRoles returns the roles the member has in the unconditional bindings
+// of the policy.
+func memberRoles(policy *cloudresourcemanager.Policy, member string) []string {
+	var out []string
+	for _, b := range policy.Bindings {
+		if b.Condition != nil {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == member {
+				out = append(out, b.Role)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// undeclaredRoles returns the roles that are not declared.
+func undeclaredRoles(roles, declared []string) []string {
+	var out []string
+	for _, r := range roles {
+		if !contains(declared, r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
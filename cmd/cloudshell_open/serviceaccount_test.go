@@ -0,0 +1,139 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func Test_serviceAccountID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"api", "api-sa"},
+		{"a", "run-a-sa"},
+		{"a12345678901234567890123456", "a12345678901234567890123456-sa"},
+		{"a123456789012345678901234567890", "a1234567890123456789-ed15d3-sa"},
+		{"a1234567890123456789012345-67890", "a1234567890123456789-78c44d-sa"},
+		{"a1234567890123456789-x234567890", "a1234567890123456789-4eccec-sa"},
+		{"a1234567890123456789-y234567890", "a1234567890123456789-a8b687-sa"},
+		{"a123456789012345678-x23456789012", "a123456789012345678-850b14-sa"},
+	}
+	for _, tt := range tests {
+		got := serviceAccountID(tt.in)
+		if got != tt.want {
+			t.Errorf("serviceAccountID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !serviceAccountIDPattern.MatchString(got) {
+			t.Errorf("serviceAccountID(%q) = %q is not a valid account ID", tt.in, got)
+		}
+	}
+}
+
+func Test_isIAMPropagationError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unknown member", fmt.Errorf("failed to grant: %w", &googleapi.Error{Code: http.StatusBadRequest,
+			Message: "Service account api-sa@proj.iam.gserviceaccount.com does not exist."}), true},
+		{"concurrent change", &googleapi.Error{Code: http.StatusConflict}, true},
+		{"invalid role", &googleapi.Error{Code: http.StatusBadRequest, Message: "Role roles/x is not supported."}, false},
+		{"permission denied", &googleapi.Error{Code: http.StatusForbidden}, false},
+		{"other error", errors.New("does not exist"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isIAMPropagationError(tt.err); got != tt.want {
+				t.Errorf("isIAMPropagationError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_serviceAccountEmail(t *testing.T) {
+	if got := serviceAccountEmail("proj", "api-sa"); got != "api-sa@proj.iam.gserviceaccount.com" {
+		t.Errorf("serviceAccountEmail() = %q", got)
+	}
+	if got := serviceAccountEmail("proj", "sa@other.iam.gserviceaccount.com"); got != "sa@other.iam.gserviceaccount.com" {
+		t.Errorf("serviceAccountEmail() = %q", got)
+	}
+}
+
+func Test_serviceAccount_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sa      serviceAccount
+		wantErr bool
+	}{
+		{"empty", serviceAccount{}, false},
+		{"account id", serviceAccount{Name: "my-app-sa"}, false},
+		{"email", serviceAccount{Name: "app@proj.iam.gserviceaccount.com"}, false},
+		{"invalid account id", serviceAccount{Name: "My_SA"}, true},
+		{"roles", serviceAccount{Roles: []string{"roles/datastore.user", "projects/p/roles/custom_role"}}, false},
+		{"invalid role", serviceAccount{Roles: []string{"datastore.user"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sa.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_addRoleBindings(t *testing.T) {
+	member := "serviceAccount:sa@proj.iam.gserviceaccount.com"
+	policy := &cloudresourcemanager.Policy{Bindings: []*cloudresourcemanager.Binding{
+		{Role: "roles/datastore.user", Members: []string{member}},
+		{Role: "roles/storage.objectViewer", Members: []string{"user:me@example.com"}},
+		{Role: "roles/pubsub.publisher", Members: []string{member},
+			Condition: &cloudresourcemanager.Expr{Expression: "request.time < timestamp('2020-01-01T00:00:00Z')"}},
+		{Role: "roles/logging.logWriter", Members: []string{member}},
+	}}
+
+	roles := []string{"roles/datastore.user", "roles/storage.objectViewer", "roles/pubsub.publisher"}
+	granted := addRoleBindings(policy, member, roles)
+	if expected := []string{"roles/storage.objectViewer", "roles/pubsub.publisher"}; !reflect.DeepEqual(granted, expected) {
+		t.Fatalf("addRoleBindings() = %v, want %v", granted, expected)
+	}
+	if got := policy.Bindings[1].Members; !reflect.DeepEqual(got, []string{"user:me@example.com", member}) {
+		t.Fatalf("existing binding not extended: %v", got)
+	}
+	if len(policy.Bindings) != 5 {
+		t.Fatalf("expected a new unconditional binding, got %d bindings", len(policy.Bindings))
+	}
+
+	if granted := addRoleBindings(policy, member, roles); len(granted) != 0 {
+		t.Fatalf("granted roles again: %v", granted)
+	}
+
+	all := memberRoles(policy, member)
+	if expected := []string{"roles/datastore.user", "roles/logging.logWriter", "roles/pubsub.publisher", "roles/storage.objectViewer"}; !reflect.DeepEqual(all, expected) {
+		t.Fatalf("memberRoles() = %v, want %v", all, expected)
+	}
+	if got, expected := undeclaredRoles(all, roles), []string{"roles/logging.logWriter"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("undeclaredRoles() = %v, want %v", got, expected)
+	}
+}
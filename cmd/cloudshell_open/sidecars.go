@@ -0,0 +1,304 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+// sidecar is a container running next to the container of the service.
+type sidecar struct {
+	Name string `json:"name"`
+	// Image is the image of the container, unless it is built from the
+	// Dockerfile in Dir.
+	Image string `json:"image"`
+	Dir   string `json:"dir"`
+	// Port makes the sidecar receive the requests instead of the container of
+	// the service.
+	Port      int               `json:"port"`
+	Env       map[string]string `json:"env"`
+	CPU       string            `json:"cpu"`
+	Memory    string            `json:"memory"`
+	DependsOn []string          `json:"depends-on"`
+}
+
+const (
+	// mainContainerName is the name of the container of the service once it
+	// has sidecars.
+	mainContainerName = "app"
+
+	containerDependenciesAnnotation = "run.googleapis.com/container-dependencies"
+)
+
+var (
+	containerNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	envNamePattern       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// validateSidecars checks the sidecars, their dependencies, and that at most
+// one of them receives the requests.
+func validateSidecars(list []sidecar) error {
+	byName := make(map[string]sidecar)
+	var ingress []string
+	for _, s := range list {
+		if !containerNamePattern.MatchString(s.Name) {
+			return fmt.Errorf("invalid sidecar name %q, must be 1-63 lowercase letters, digits and hyphens", s.Name)
+		}
+		if s.Name == mainContainerName {
+			return fmt.Errorf("sidecar name %q is reserved for the container of the service", s.Name)
+		}
+		if _, ok := byName[s.Name]; ok {
+			return fmt.Errorf("sidecar %q is declared more than once", s.Name)
+		}
+		byName[s.Name] = s
+		if (s.Image == "") == (s.Dir == "") {
+			return fmt.Errorf("sidecar %q must have exactly one of image and dir", s.Name)
+		}
+		if s.Dir != "" && (filepath.IsAbs(s.Dir) || strings.HasPrefix(filepath.Clean(s.Dir), "..")) {
+			return fmt.Errorf("dir of sidecar %q must be relative to the service directory", s.Name)
+		}
+		if s.Port < 0 || s.Port > 65535 {
+			return fmt.Errorf("invalid port %d of sidecar %q", s.Port, s.Name)
+		}
+		if s.Port > 0 {
+			ingress = append(ingress, s.Name)
+		}
+		for k := range s.Env {
+			if !envNamePattern.MatchString(k) {
+				return fmt.Errorf("invalid env var name %q of sidecar %q", k, s.Name)
+			}
+		}
+	}
+	if len(ingress) > 1 {
+		return fmt.Errorf("only one container can receive requests, but sidecars %s have a port", strings.Join(ingress, ", "))
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("sidecars have a dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range byName[name].DependsOn {
+			if dep == mainContainerName {
+				continue
+			}
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("sidecar %q depends on unknown container %q", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, s := range list {
+		if err := visit(s.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingressPort returns the port of the container receiving the requests: the
+// sidecar with a port if there is one, or else the main container.
+func ingressPort(o options, list []sidecar) int {
+	for _, s := range list {
+		if s.Port > 0 {
+			return s.Port
+		}
+	}
+	return int(optionsToContainerSpec(o).ContainerPort)
+}
+
+// ingressContainerPort returns the port of the container of the Service that
+// receives the requests, or 0 if none has a port.
+func ingressContainerPort(svc *runapi.Service) int {
+	for _, c := range svc.Spec.Template.Spec.Containers {
+		if len(c.Ports) > 0 {
+			return int(c.Ports[0].ContainerPort)
+		}
+	}
+	return 0
+}
+
+// sidecarImage returns the image of the sidecar, where image is the image of
+// the service.
+func sidecarImage(image string, s sidecar) string {
+	if s.Image != "" {
+		return s.Image
+	}
+	return image + "-" + s.Name
+}
+
+// mainContainer returns the container of the service: the one named after
+// mainContainerName, or the first one for services without sidecars.
+func mainContainer(svc *runapi.Service) *runapi.Container {
+	containers := svc.Spec.Template.Spec.Containers
+	for _, c := range containers {
+		if c.Name == mainContainerName {
+			return c
+		}
+	}
+	return containers[0]
+}
+
+// sidecarContainer returns the container of the sidecar.
+func sidecarContainer(image string, s sidecar) *runapi.Container {
+	c := &runapi.Container{
+		Name:      s.Name,
+		Image:     sidecarImage(image, s),
+		Resources: optionsToResourceRequirements(options{CPU: s.CPU, Memory: s.Memory}),
+	}
+	for _, k := range sortedKeys(s.Env) {
+		c.Env = append(c.Env, &runapi.EnvVar{Name: k, Value: s.Env[k]})
+	}
+	if s.Port > 0 {
+		c.Ports = []*runapi.ContainerPort{{ContainerPort: int64(s.Port), Name: "http1"}}
+	}
+	return c
+}
+
+// withSidecars sets the sidecars of the revisions of the Service, replacing the
+// containers with the same names and removing the sidecars that are no longer
+// declared. image is the image of the service.
+func withSidecars(image string, list []sidecar) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		spec := svc.Spec.Template.Spec
+		main := mainContainer(svc)
+		if len(list) > 0 {
+			main.Name = mainContainerName
+		}
+		declared := map[string]bool{main.Name: true}
+		for _, s := range list {
+			declared[s.Name] = true
+		}
+		containers := []*runapi.Container{}
+		for _, c := range spec.Containers {
+			if c == main || declared[c.Name] {
+				containers = append(containers, c)
+			}
+		}
+		spec.Containers = containers
+		for _, s := range list {
+			spec.Containers = replaceContainer(spec.Containers, sidecarContainer(image, s))
+			if s.Port > 0 {
+				// only one container can have a port
+				main.Ports = nil
+			}
+		}
+
+		meta := svc.Spec.Template.Metadata
+		deps := make(map[string][]string)
+		if v := meta.Annotations[containerDependenciesAnnotation]; v != "" {
+			// dependencies of the main container are kept if they can be read
+			_ = json.Unmarshal([]byte(v), &deps)
+		}
+		for k := range deps {
+			if k != main.Name {
+				delete(deps, k)
+			}
+		}
+		for _, s := range list {
+			if len(s.DependsOn) > 0 {
+				deps[s.Name] = s.DependsOn
+			}
+		}
+		if len(deps) == 0 {
+			delete(meta.Annotations, containerDependenciesAnnotation)
+			return
+		}
+		b, _ := json.Marshal(deps)
+		meta.Annotations[containerDependenciesAnnotation] = string(b)
+	}
+}
+
+func replaceContainer(list []*runapi.Container, c *runapi.Container) []*runapi.Container {
+	for i, e := range list {
+		if e.Name == c.Name {
+			list[i] = c
+			return list
+		}
+	}
+	return append(list, c)
+}
+
+// removedSidecars returns the names of the sidecars of the existing Service
+// that are no longer declared.
+func removedSidecars(existing *runapi.Service, list []sidecar) []string {
+	if existing == nil {
+		return nil
+	}
+	declared := make(map[string]bool)
+	for _, s := range list {
+		declared[s.Name] = true
+	}
+	main := mainContainer(existing)
+	var out []string
+	for _, c := range existing.Spec.Template.Spec.Containers {
+		if c != main && !declared[c.Name] {
+			out = append(out, c.Name)
+		}
+	}
+	return out
+}
+
+// sidecarFlags returns the gcloud flags adding the sidecars to the service.
+// image is the image of the service.
+func sidecarFlags(image string, list []sidecar) []string {
+	var flags []string
+	for _, s := range list {
+		flags = append(flags, "--container="+s.Name, "--image="+sidecarImage(image, s))
+		if s.Port > 0 {
+			flags = append(flags, "--port="+strconv.Itoa(s.Port))
+		}
+		if len(s.Env) > 0 {
+			var envs []string
+			for _, k := range sortedKeys(s.Env) {
+				envs = append(envs, k+"="+s.Env[k])
+			}
+			flags = append(flags, "--set-env-vars="+strings.Join(envs, ","))
+		}
+		if s.CPU != "" {
+			flags = append(flags, "--cpu="+s.CPU)
+		}
+		if s.Memory != "" {
+			flags = append(flags, "--memory="+s.Memory)
+		}
+		if len(s.DependsOn) > 0 {
+			deps := append([]string(nil), s.DependsOn...)
+			sort.Strings(deps)
+			flags = append(flags, "--depends-on="+strings.Join(deps, ","))
+		}
+	}
+	return flags
+}
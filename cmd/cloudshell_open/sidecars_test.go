@@ -0,0 +1,161 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+func Test_validateSidecars(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []sidecar
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", []sidecar{
+			{Name: "otel", Image: "otel/opentelemetry-collector", DependsOn: []string{"app"}},
+			{Name: "proxy", Dir: "nginx", Port: 8080, DependsOn: []string{"otel"}},
+		}, false},
+		{"invalid name", []sidecar{{Name: "Proxy", Image: "nginx"}}, true},
+		{"reserved name", []sidecar{{Name: "app", Image: "nginx"}}, true},
+		{"duplicate name", []sidecar{{Name: "a", Image: "nginx"}, {Name: "a", Image: "nginx"}}, true},
+		{"no image", []sidecar{{Name: "a"}}, true},
+		{"image and dir", []sidecar{{Name: "a", Image: "nginx", Dir: "nginx"}}, true},
+		{"dir outside the repository", []sidecar{{Name: "a", Dir: "../nginx"}}, true},
+		{"invalid port", []sidecar{{Name: "a", Image: "nginx", Port: 70000}}, true},
+		{"two ingress sidecars", []sidecar{{Name: "a", Image: "nginx", Port: 80}, {Name: "b", Image: "nginx", Port: 81}}, true},
+		{"invalid env", []sidecar{{Name: "a", Image: "nginx", Env: map[string]string{"A-B": "1"}}}, true},
+		{"unknown dependency", []sidecar{{Name: "a", Image: "nginx", DependsOn: []string{"b"}}}, true},
+		{"dependency cycle", []sidecar{
+			{Name: "a", Image: "nginx", DependsOn: []string{"b"}},
+			{Name: "b", Image: "nginx", DependsOn: []string{"a"}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSidecars(tt.list); (err != nil) != tt.wantErr {
+				t.Errorf("validateSidecars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_withSidecars(t *testing.T) {
+	list := []sidecar{
+		{Name: "proxy", Dir: "nginx", Port: 8080, DependsOn: []string{"app"}},
+		{Name: "otel", Image: "otel/opentelemetry-collector", Env: map[string]string{"B": "2", "A": "1"}, Memory: "256Mi"},
+	}
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{}, withSidecars("image", list))
+
+	containers := svc.Spec.Template.Spec.Containers
+	if len(containers) != 3 {
+		t.Fatalf("newService() has %d containers, want 3", len(containers))
+	}
+	if c := containers[0]; c.Name != mainContainerName || c.Image != "image" || len(c.Ports) != 0 {
+		t.Fatalf("wrong main container %#v", c)
+	}
+	expected := &runapi.Container{
+		Name:      "proxy",
+		Image:     "image-proxy",
+		Resources: &runapi.ResourceRequirements{Limits: map[string]string{}},
+		Ports:     []*runapi.ContainerPort{{ContainerPort: 8080, Name: "http1"}},
+	}
+	if !reflect.DeepEqual(containers[1], expected) {
+		t.Fatalf("wrong proxy container %#v", containers[1])
+	}
+	if got := containers[2].Env; len(got) != 2 || got[0].Name != "A" || got[1].Name != "B" {
+		t.Fatalf("wrong otel env %s", envVarsString(got))
+	}
+	if got := svc.Spec.Template.Metadata.Annotations[containerDependenciesAnnotation]; got != `{"proxy":["app"]}` {
+		t.Fatalf("wrong %s annotation %s", containerDependenciesAnnotation, got)
+	}
+
+	// containers are updated by name, and undeclared sidecars are removed
+	svc.Spec.Template.Spec.Containers = []*runapi.Container{containers[2], containers[0], containers[1]}
+	if got := removedSidecars(svc, list[:1]); !reflect.DeepEqual(got, []string{"otel"}) {
+		t.Fatalf("removedSidecars() = %v", got)
+	}
+	svc.Spec.Template.Metadata.Annotations[containerDependenciesAnnotation] = `{"proxy":["app"],"otel":["proxy"]}`
+	svc = patchService(svc, map[string]string{"KEY": "value"}, nil, envSync{}, "new-image", options{}, withSidecars("new-image", list[:1]))
+	containers = svc.Spec.Template.Spec.Containers
+	if len(containers) != 2 {
+		t.Fatalf("patchService() has %d containers, want 2", len(containers))
+	}
+	if c := containers[0]; c.Name != mainContainerName || c.Image != "new-image" || len(c.Ports) != 0 || len(c.Env) != 1 {
+		t.Fatalf("main container not updated %#v", c)
+	}
+	if c := containers[1]; c.Name != "proxy" || c.Image != "new-image-proxy" {
+		t.Fatalf("proxy container not updated %#v", c)
+	}
+	if got := svc.Spec.Template.Metadata.Annotations[containerDependenciesAnnotation]; got != `{"proxy":["app"]}` {
+		t.Fatalf("wrong %s annotation %s", containerDependenciesAnnotation, got)
+	}
+
+	// the main container receives requests again without ingress sidecars
+	svc = patchService(svc, nil, nil, envSync{}, "new-image", options{Port: 9090},
+		withSidecars("new-image", []sidecar{{Name: "proxy", Image: "nginx"}}))
+	if got := mainContainer(svc).Ports; len(got) != 1 || got[0].ContainerPort != 9090 {
+		t.Fatalf("main container ports = %#v", got)
+	}
+	if _, ok := svc.Spec.Template.Metadata.Annotations[containerDependenciesAnnotation]; ok {
+		t.Fatalf("%s annotation not removed", containerDependenciesAnnotation)
+	}
+
+	// no sidecars left
+	svc = patchService(svc, nil, nil, envSync{}, "new-image", options{}, withSidecars("new-image", nil))
+	if containers := svc.Spec.Template.Spec.Containers; len(containers) != 1 || containers[0].Image != "new-image" {
+		t.Fatalf("sidecars not removed %#v", containers)
+	}
+}
+
+func Test_withSidecars_probePort(t *testing.T) {
+	list := []sidecar{{Name: "proxy", Image: "nginx", Port: 8081}}
+	p := probes{Startup: &probe{Type: "tcp"}}
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{}, withSidecars("image", list), withProbes(p))
+	if got := mainContainer(svc).StartupProbe.TcpSocket.Port; got != 8081 {
+		t.Fatalf("startup probe port = %d, want the ingress port 8081", got)
+	}
+	if got := ingressPort(options{}, list); got != 8081 {
+		t.Fatalf("ingressPort() = %d, want 8081", got)
+	}
+	if got := ingressPort(options{Port: 9090}, nil); got != 9090 {
+		t.Fatalf("ingressPort() = %d, want 9090", got)
+	}
+}
+
+func Test_mainContainer(t *testing.T) {
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{})
+	if got := mainContainer(svc); got != svc.Spec.Template.Spec.Containers[0] {
+		t.Fatalf("mainContainer() = %#v, want the only container", got)
+	}
+}
+
+func Test_sidecarFlags(t *testing.T) {
+	list := []sidecar{
+		{Name: "proxy", Dir: "nginx", Port: 8080, CPU: "1", DependsOn: []string{"otel", "app"}},
+		{Name: "otel", Image: "otel/opentelemetry-collector", Env: map[string]string{"B": "2", "A": "1"}, Memory: "256Mi"},
+	}
+	expected := []string{
+		"--container=proxy", "--image=image-proxy", "--port=8080", "--cpu=1", "--depends-on=app,otel",
+		"--container=otel", "--image=otel/opentelemetry-collector", "--set-env-vars=A=1,B=2", "--memory=256Mi",
+	}
+	if got := sidecarFlags("image", list); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("sidecarFlags() = %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,397 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	runapi "google.golang.org/api/run/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+// volume is a volume mounted in the container of the service. Exactly one of
+// its sources must be set.
+type volume struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount-path"`
+
+	CloudStorage *cloudStorageVolume `json:"cloud-storage"`
+	NFS          *nfsVolume          `json:"nfs"`
+	InMemory     *inMemoryVolume     `json:"in-memory"`
+	Secret       *secretVolume       `json:"secret"`
+	File         *fileVolume         `json:"file"`
+}
+
+// cloudStorageVolume mounts a Cloud Storage bucket, which is created if it
+// doesn't exist.
+type cloudStorageVolume struct {
+	Bucket   string `json:"bucket"`
+	ReadOnly bool   `json:"read-only"`
+}
+
+// nfsVolume mounts an NFS share, such as a Filestore instance.
+type nfsVolume struct {
+	Server   string `json:"server"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read-only"`
+}
+
+// inMemoryVolume is an empty directory backed by the memory of the instance.
+type inMemoryVolume struct {
+	SizeLimit string `json:"size-limit"`
+}
+
+// secretVolume mounts a version of an existing Secret Manager secret as a file.
+type secretVolume struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+}
+
+// fileVolume mounts a file of the repository. The file is uploaded as a
+// version of a Secret Manager secret of the service.
+type fileVolume struct {
+	Path string `json:"path"`
+}
+
+const (
+	gcsFuseDriver        = "gcsfuse.run.googleapis.com"
+	executionEnvironment = "run.googleapis.com/execution-environment"
+)
+
+var (
+	volumeNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,61}[a-z0-9]$`)
+	secretIDPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+	sizeLimitPattern  = regexp.MustCompile(`^[1-9][0-9]*(Ki|Mi|Gi|k|M|G)?$`)
+)
+
+// validateVolumes checks the volumes, and that their names and mount paths are
+// unique.
+func validateVolumes(list []volume) error {
+	names := make(map[string]bool)
+	mountPaths := make(map[string]bool)
+	for _, v := range list {
+		if err := v.validate(); err != nil {
+			return fmt.Errorf("invalid volume %q: %w", v.Name, err)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("volume %q is declared more than once", v.Name)
+		}
+		names[v.Name] = true
+		mp := path.Clean(v.MountPath)
+		if mountPaths[mp] {
+			return fmt.Errorf("more than one volume is mounted at %s", mp)
+		}
+		mountPaths[mp] = true
+	}
+	return nil
+}
+
+// validate checks the settings of the volume.
+func (v volume) validate() error {
+	if !volumeNamePattern.MatchString(v.Name) {
+		return fmt.Errorf("name must be 1-63 lowercase letters, digits and hyphens")
+	}
+	if !path.IsAbs(v.MountPath) || path.Clean(v.MountPath) == "/" {
+		return fmt.Errorf("mount-path must be an absolute path other than /")
+	}
+
+	var sources int
+	for _, set := range []bool{v.CloudStorage != nil, v.NFS != nil, v.InMemory != nil, v.Secret != nil, v.File != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of cloud-storage, nfs, in-memory, secret and file must be set")
+	}
+
+	switch {
+	case v.CloudStorage != nil:
+		if b := v.CloudStorage.Bucket; b != "" && (!bucketNamePattern.MatchString(b) || strings.Contains(b, "google")) {
+			return fmt.Errorf("invalid bucket name %q", b)
+		}
+	case v.NFS != nil:
+		if v.NFS.Server == "" {
+			return fmt.Errorf("nfs server must be set")
+		}
+		if !path.IsAbs(v.NFS.Path) {
+			return fmt.Errorf("nfs path must be an absolute path")
+		}
+	case v.InMemory != nil:
+		if l := v.InMemory.SizeLimit; l != "" && !sizeLimitPattern.MatchString(l) {
+			return fmt.Errorf("invalid size-limit %q, must be like 512Mi", l)
+		}
+	case v.Secret != nil:
+		if !secretIDPattern.MatchString(v.Secret.Name) {
+			return fmt.Errorf("invalid secret name %q", v.Secret.Name)
+		}
+		if p := v.Secret.Path; p != "" && strings.Contains(p, "/") {
+			return fmt.Errorf("secret path must be a file name")
+		}
+	case v.File != nil:
+		p := v.File.Path
+		if p == "" || filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p), "..") {
+			return fmt.Errorf("file path must be relative to the service directory")
+		}
+	}
+	return nil
+}
+
+// needsGen2 checks if any of the volumes requires the second generation
+// execution environment.
+func needsGen2(list []volume) bool {
+	for _, v := range list {
+		if v.CloudStorage != nil || v.NFS != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketName returns the name of the bucket of the Cloud Storage volume.
+func bucketName(project, service string, v volume) string {
+	if v.CloudStorage.Bucket != "" {
+		return v.CloudStorage.Bucket
+	}
+	name := fmt.Sprintf("%s-%s-%s", project, service, v.Name)
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-.")
+	}
+	return name
+}
+
+// fileSecretName returns the ID of the Secret Manager secret that holds the
+// repository file of the volume.
+func fileSecretName(service string, v volume) string {
+	return secretName(service, "file-"+v.Name)
+}
+
+// setupVolumes creates the missing buckets, uploads the repository files and
+// lets the runtime service account access the buckets and secrets. An empty
+// runtimeAccount means the default service account. The buckets and secrets
+// are created with the settings of the resources.
+func setupVolumes(project, region, service, dir, runtimeAccount string, list []volume, res resourceConfig) error {
+	if runtimeAccount == "" {
+		var err error
+		runtimeAccount, err = defaultServiceAccount(project)
+		if err != nil {
+			return err
+		}
+	}
+	member := "serviceAccount:" + runtimeAccount
+
+	for _, v := range list {
+		switch {
+		case v.CloudStorage != nil:
+			bucket := bucketName(project, service, v)
+			if err := ensureBucket(project, region, bucket, res.labels); err != nil {
+				return err
+			}
+			role := "roles/storage.objectUser"
+			if v.CloudStorage.ReadOnly {
+				role = "roles/storage.objectViewer"
+			}
+			if err := grantBucketAccess(bucket, member, role); err != nil {
+				return err
+			}
+		case v.Secret != nil:
+			if err := grantSecretAccess(project, v.Secret.Name, member); err != nil {
+				return err
+			}
+		case v.File != nil:
+			b, err := ioutil.ReadFile(filepath.Join(dir, v.File.Path))
+			if err != nil {
+				return fmt.Errorf("failed to read file of volume %q: %w", v.Name, err)
+			}
+			name := fileSecretName(service, v)
+			if err := ensureSecret(project, name, string(b), res); err != nil {
+				return err
+			}
+			if err := grantSecretAccess(project, name, member); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureBucket creates the bucket in the region with the labels if it doesn't
+// already exist.
+func ensureBucket(project, region, bucket string, labels map[string]string) error {
+	client, err := storage.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Cloud Storage client: %w", err)
+	}
+	if _, err := client.Buckets.Get(bucket).Do(); err == nil {
+		return nil
+	} else if !isNotFound(err) {
+		return fmt.Errorf("failed to get bucket %s: %w", bucket, err)
+	}
+	if _, err := client.Buckets.Insert(project, &storage.Bucket{
+		Name:     bucket,
+		Location: region,
+		Labels:   labels,
+		IamConfiguration: &storage.BucketIamConfiguration{
+			UniformBucketLevelAccess: &storage.BucketIamConfigurationUniformBucketLevelAccess{Enabled: true},
+		},
+	}).Do(); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// grantBucketAccess grants the role on the bucket to the member unless it
+// already has it.
+func grantBucketAccess(bucket, member, role string) error {
+	client, err := storage.NewService(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Cloud Storage client: %w", err)
+	}
+	policy, err := client.Buckets.GetIamPolicy(bucket).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get IAM policy for bucket %s: %w", bucket, err)
+	}
+	for _, b := range policy.Bindings {
+		if b.Role == role && b.Condition == nil && contains(b.Members, member) {
+			return nil
+		}
+	}
+	policy.Bindings = append(policy.Bindings, &storage.PolicyBindings{
+		Members: []string{member},
+		Role:    role,
+	})
+	if _, err := client.Buckets.SetIamPolicy(bucket, policy).Do(); err != nil {
+		return fmt.Errorf("failed to set IAM policy for bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// runVolume returns the volume of the Service and its mount in the container.
+func runVolume(project, service string, v volume) (*runapi.Volume, *runapi.VolumeMount) {
+	out := &runapi.Volume{Name: v.Name}
+	switch {
+	case v.CloudStorage != nil:
+		out.Csi = &runapi.CSIVolumeSource{
+			Driver:           gcsFuseDriver,
+			ReadOnly:         v.CloudStorage.ReadOnly,
+			VolumeAttributes: map[string]string{"bucketName": bucketName(project, service, v)},
+		}
+	case v.NFS != nil:
+		out.Nfs = &runapi.NFSVolumeSource{Server: v.NFS.Server, Path: v.NFS.Path, ReadOnly: v.NFS.ReadOnly}
+	case v.InMemory != nil:
+		out.EmptyDir = &runapi.EmptyDirVolumeSource{Medium: "Memory", SizeLimit: v.InMemory.SizeLimit}
+	case v.Secret != nil:
+		version, file := v.Secret.Version, v.Secret.Path
+		if version == "" {
+			version = "latest"
+		}
+		if file == "" {
+			file = v.Secret.Name
+		}
+		out.Secret = &runapi.SecretVolumeSource{
+			SecretName: v.Secret.Name,
+			Items:      []*runapi.KeyToPath{{Key: version, Path: file}},
+		}
+	case v.File != nil:
+		out.Secret = &runapi.SecretVolumeSource{
+			SecretName: fileSecretName(service, v),
+			Items:      []*runapi.KeyToPath{{Key: "latest", Path: filepath.Base(v.File.Path)}},
+		}
+	}
+	return out, &runapi.VolumeMount{Name: v.Name, MountPath: v.MountPath}
+}
+
+// withVolumes adds the volumes to the revisions of the Service and mounts them
+// in its container, replacing the volumes with the same names.
+func withVolumes(project, service string, list []volume) func(*runapi.Service) {
+	return func(svc *runapi.Service) {
+		spec := svc.Spec.Template.Spec
+		c := mainContainer(svc)
+		for _, v := range list {
+			vol, mount := runVolume(project, service, v)
+			spec.Volumes = replaceVolume(spec.Volumes, vol)
+			c.VolumeMounts = replaceVolumeMount(c.VolumeMounts, mount)
+		}
+		if needsGen2(list) {
+			svc.Spec.Template.Metadata.Annotations[executionEnvironment] = "gen2"
+		}
+	}
+}
+
+func replaceVolume(list []*runapi.Volume, v *runapi.Volume) []*runapi.Volume {
+	for i, e := range list {
+		if e.Name == v.Name {
+			list[i] = v
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+func replaceVolumeMount(list []*runapi.VolumeMount, m *runapi.VolumeMount) []*runapi.VolumeMount {
+	for i, e := range list {
+		if e.Name == m.Name {
+			list[i] = m
+			return list
+		}
+	}
+	return append(list, m)
+}
+
+// volumeFlags returns the gcloud flags adding the volumes and their mounts.
+func volumeFlags(project, service string, list []volume) []string {
+	var flags []string
+	for _, v := range list {
+		var spec string
+		switch {
+		case v.CloudStorage != nil:
+			spec = "type=cloud-storage,bucket=" + bucketName(project, service, v)
+			if v.CloudStorage.ReadOnly {
+				spec += ",readonly=true"
+			}
+		case v.NFS != nil:
+			spec = fmt.Sprintf("type=nfs,location=%s:%s", v.NFS.Server, v.NFS.Path)
+			if v.NFS.ReadOnly {
+				spec += ",readonly=true"
+			}
+		case v.InMemory != nil:
+			spec = "type=in-memory"
+			if v.InMemory.SizeLimit != "" {
+				spec += ",size-limit=" + v.InMemory.SizeLimit
+			}
+		case v.Secret != nil:
+			vol, _ := runVolume(project, service, v)
+			spec = fmt.Sprintf("type=secret,secret=%s,version=%s,path=%s", v.Secret.Name, vol.Secret.Items[0].Key, vol.Secret.Items[0].Path)
+		case v.File != nil:
+			spec = fmt.Sprintf("type=secret,secret=%s,version=latest,path=%s", fileSecretName(service, v), filepath.Base(v.File.Path))
+		}
+		flags = append(flags, fmt.Sprintf("--add-volume=name=%s,%s", v.Name, spec))
+	}
+	for _, v := range list {
+		flags = append(flags, fmt.Sprintf("--add-volume-mount=volume=%s,mount-path=%s", v.Name, v.MountPath))
+	}
+	if needsGen2(list) {
+		flags = append(flags, "--execution-environment=gen2")
+	}
+	return flags
+}
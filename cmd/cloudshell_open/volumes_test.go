@@ -0,0 +1,137 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	runapi "google.golang.org/api/run/v1"
+)
+
+func Test_validateVolumes(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []volume
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"all kinds", []volume{
+			{Name: "data", MountPath: "/data", CloudStorage: &cloudStorageVolume{}},
+			{Name: "share", MountPath: "/mnt/share", NFS: &nfsVolume{Server: "10.0.0.2", Path: "/share"}},
+			{Name: "cache", MountPath: "/cache", InMemory: &inMemoryVolume{SizeLimit: "256Mi"}},
+			{Name: "creds", MountPath: "/secrets", Secret: &secretVolume{Name: "api-key", Path: "key.json"}},
+			{Name: "config", MountPath: "/config", File: &fileVolume{Path: "config/settings.yaml"}},
+		}, false},
+		{"no source", []volume{{Name: "data", MountPath: "/data"}}, true},
+		{"two sources", []volume{{Name: "data", MountPath: "/data",
+			CloudStorage: &cloudStorageVolume{}, InMemory: &inMemoryVolume{}}}, true},
+		{"invalid name", []volume{{Name: "Data", MountPath: "/data", InMemory: &inMemoryVolume{}}}, true},
+		{"relative mount-path", []volume{{Name: "data", MountPath: "data", InMemory: &inMemoryVolume{}}}, true},
+		{"root mount-path", []volume{{Name: "data", MountPath: "/", InMemory: &inMemoryVolume{}}}, true},
+		{"duplicate name", []volume{
+			{Name: "data", MountPath: "/a", InMemory: &inMemoryVolume{}},
+			{Name: "data", MountPath: "/b", InMemory: &inMemoryVolume{}},
+		}, true},
+		{"duplicate mount-path", []volume{
+			{Name: "a", MountPath: "/data", InMemory: &inMemoryVolume{}},
+			{Name: "b", MountPath: "/data/", InMemory: &inMemoryVolume{}},
+		}, true},
+		{"invalid bucket", []volume{{Name: "data", MountPath: "/data", CloudStorage: &cloudStorageVolume{Bucket: "My_Bucket"}}}, true},
+		{"nfs without server", []volume{{Name: "share", MountPath: "/share", NFS: &nfsVolume{Path: "/share"}}}, true},
+		{"invalid size-limit", []volume{{Name: "cache", MountPath: "/cache", InMemory: &inMemoryVolume{SizeLimit: "1 GB"}}}, true},
+		{"secret without name", []volume{{Name: "creds", MountPath: "/secrets", Secret: &secretVolume{}}}, true},
+		{"file outside the repository", []volume{{Name: "config", MountPath: "/config", File: &fileVolume{Path: "../settings.yaml"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateVolumes(tt.list); (err != nil) != tt.wantErr {
+				t.Errorf("validateVolumes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_bucketName(t *testing.T) {
+	v := volume{Name: "data", CloudStorage: &cloudStorageVolume{}}
+	if got := bucketName("proj", "svc", v); got != "proj-svc-data" {
+		t.Errorf("bucketName() = %q", got)
+	}
+	if got := bucketName("proj", strings.Repeat("s", 60), v); len(got) > 63 || strings.HasSuffix(got, "-") {
+		t.Errorf("bucketName() = %q is not a valid bucket name", got)
+	}
+	v.CloudStorage.Bucket = "my-bucket"
+	if got := bucketName("proj", "svc", v); got != "my-bucket" {
+		t.Errorf("bucketName() = %q", got)
+	}
+}
+
+func Test_withVolumes(t *testing.T) {
+	list := []volume{
+		{Name: "data", MountPath: "/data", CloudStorage: &cloudStorageVolume{ReadOnly: true}},
+		{Name: "cache", MountPath: "/cache", InMemory: &inMemoryVolume{SizeLimit: "256Mi"}},
+		{Name: "creds", MountPath: "/secrets", Secret: &secretVolume{Name: "api-key"}},
+		{Name: "config", MountPath: "/config", File: &fileVolume{Path: "config/settings.yaml"}},
+	}
+	svc := newService("svc", "proj", "image", nil, nil, envSync{}, options{}, withVolumes("proj", "svc", list))
+
+	expected := []*runapi.Volume{
+		{Name: "data", Csi: &runapi.CSIVolumeSource{Driver: gcsFuseDriver, ReadOnly: true,
+			VolumeAttributes: map[string]string{"bucketName": "proj-svc-data"}}},
+		{Name: "cache", EmptyDir: &runapi.EmptyDirVolumeSource{Medium: "Memory", SizeLimit: "256Mi"}},
+		{Name: "creds", Secret: &runapi.SecretVolumeSource{SecretName: "api-key",
+			Items: []*runapi.KeyToPath{{Key: "latest", Path: "api-key"}}}},
+		{Name: "config", Secret: &runapi.SecretVolumeSource{SecretName: "svc-file-config",
+			Items: []*runapi.KeyToPath{{Key: "latest", Path: "settings.yaml"}}}},
+	}
+	if got := svc.Spec.Template.Spec.Volumes; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("newService() volumes = %#v", got)
+	}
+	if got := svc.Spec.Template.Spec.Containers[0].VolumeMounts; len(got) != 4 || got[3].Name != "config" || got[3].MountPath != "/config" {
+		t.Fatalf("newService() volume mounts = %#v", got)
+	}
+	if got := svc.Spec.Template.Metadata.Annotations[executionEnvironment]; got != "gen2" {
+		t.Fatalf("newService() %s = %q, want gen2", executionEnvironment, got)
+	}
+
+	// existing volumes are kept, and replaced if redeclared
+	svc.Spec.Template.Spec.Volumes = []*runapi.Volume{{Name: "other", EmptyDir: &runapi.EmptyDirVolumeSource{}}, {Name: "cache"}}
+	svc.Spec.Template.Spec.Containers[0].VolumeMounts = []*runapi.VolumeMount{{Name: "other", MountPath: "/other"}, {Name: "cache", MountPath: "/tmp"}}
+	svc = patchService(svc, nil, nil, envSync{}, "image", options{}, withVolumes("proj", "svc", list[1:2]))
+	if got := svc.Spec.Template.Spec.Volumes; len(got) != 2 || got[0].Name != "other" || got[1].EmptyDir == nil {
+		t.Fatalf("patchService() volumes = %#v", got)
+	}
+	if got := svc.Spec.Template.Spec.Containers[0].VolumeMounts; len(got) != 2 || got[1].MountPath != "/cache" {
+		t.Fatalf("patchService() volume mounts = %#v", got)
+	}
+}
+
+func Test_volumeFlags(t *testing.T) {
+	list := []volume{
+		{Name: "share", MountPath: "/share", NFS: &nfsVolume{Server: "10.0.0.2", Path: "/vol1", ReadOnly: true}},
+		{Name: "creds", MountPath: "/secrets", Secret: &secretVolume{Name: "api-key", Version: "2", Path: "key.json"}},
+	}
+	expected := []string{
+		"--add-volume=name=share,type=nfs,location=10.0.0.2:/vol1,readonly=true",
+		"--add-volume=name=creds,type=secret,secret=api-key,version=2,path=key.json",
+		"--add-volume-mount=volume=share,mount-path=/share",
+		"--add-volume-mount=volume=creds,mount-path=/secrets",
+		"--execution-environment=gen2",
+	}
+	if got := volumeFlags("proj", "svc", list); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("volumeFlags() = %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// whenExpr is a parsed "when" condition of an env var. Conditions compare
+// variables with "==" and "!=", test variables for truthiness, and combine
+// them with "!", "&&", "||" and parentheses, e.g.
+//
+//	ENABLE_EMAIL && SMTP_PROVIDER != "sendgrid"
+type whenExpr struct {
+	op    string // one of "var", "==", "!=", "!", "&&", "||"
+	name  string
+	value string
+	args  []*whenExpr
+}
+
+// parseWhen parses a "when" condition.
+func parseWhen(s string) (*whenExpr, error) {
+	p := &whenParser{in: s}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos].text, s)
+	}
+	return e, nil
+}
+
+// eval evaluates the condition, lookup returns the values of the variables.
+func (w *whenExpr) eval(lookup func(string) string) bool {
+	switch w.op {
+	case "var":
+		return isTruthy(lookup(w.name))
+	case "==":
+		return lookup(w.name) == w.value
+	case "!=":
+		return lookup(w.name) != w.value
+	case "!":
+		return !w.args[0].eval(lookup)
+	case "&&":
+		return w.args[0].eval(lookup) && w.args[1].eval(lookup)
+	case "||":
+		return w.args[0].eval(lookup) || w.args[1].eval(lookup)
+	}
+	return false
+}
+
+// refs returns the names of the variables the condition refers to.
+func (w *whenExpr) refs() []string {
+	if w.name != "" {
+		return []string{w.name}
+	}
+	var out []string
+	for _, a := range w.args {
+		out = append(out, a.refs()...)
+	}
+	return out
+}
+
+// isTruthy checks if a value enables a condition: it has to be non-empty and
+// not a false boolean such as "false" or "0".
+func isTruthy(v string) bool {
+	if v == "" {
+		return false
+	}
+	if b, err := strconv.ParseBool(v); err == nil {
+		return b
+	}
+	return true
+}
+
+type whenToken struct {
+	kind string // "ident", "string", or the operator itself
+	text string
+}
+
+type whenParser struct {
+	in     string
+	tokens []whenToken
+	pos    int
+}
+
+func (p *whenParser) tokenize() error {
+	s := p.in
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="):
+			p.tokens = append(p.tokens, whenToken{kind: s[i : i+2], text: s[i : i+2]})
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			p.tokens = append(p.tokens, whenToken{kind: string(c), text: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return fmt.Errorf("unterminated string in condition %q", s)
+			}
+			p.tokens = append(p.tokens, whenToken{kind: "string", text: s[i+1 : i+1+end]})
+			i += end + 2
+		case isWordChar(c):
+			j := i
+			for j < len(s) {
+				c, size := utf8.DecodeRuneInString(s[j:])
+				if !isWordChar(c) {
+					break
+				}
+				j += size
+			}
+			p.tokens = append(p.tokens, whenToken{kind: "ident", text: s[i:j]})
+			i = j
+		default:
+			return fmt.Errorf("unexpected %q in condition %q", c, s)
+		}
+	}
+	if len(p.tokens) == 0 {
+		return fmt.Errorf("empty condition")
+	}
+	return nil
+}
+
+func isWordChar(c rune) bool {
+	return c == '_' || c == '-' || c == '.' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func (p *whenParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *whenParser) parseOr() (*whenExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &whenExpr{op: "||", args: []*whenExpr{left, right}}
+	}
+	return left, nil
+}
+
+func (p *whenParser) parseAnd() (*whenExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &whenExpr{op: "&&", args: []*whenExpr{left, right}}
+	}
+	return left, nil
+}
+
+func (p *whenParser) parseUnary() (*whenExpr, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &whenExpr{op: "!", args: []*whenExpr{e}}, nil
+	case "(":
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')' in condition %q", p.in)
+		}
+		p.pos++
+		return e, nil
+	case "ident":
+		name := p.tokens[p.pos].text
+		p.pos++
+		if op := p.peek(); op == "==" || op == "!=" {
+			p.pos++
+			if k := p.peek(); k != "ident" && k != "string" {
+				return nil, fmt.Errorf("missing value after %q in condition %q", op, p.in)
+			}
+			value := p.tokens[p.pos].text
+			p.pos++
+			return &whenExpr{op: op, name: name, value: value}, nil
+		}
+		return &whenExpr{op: "var", name: name}, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition %q", p.in)
+	}
+	return nil, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos].text, p.in)
+}
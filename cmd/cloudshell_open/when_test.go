@@ -0,0 +1,97 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseWhen(t *testing.T) {
+	vars := map[string]string{
+		"ENABLED":  "true",
+		"DISABLED": "false",
+		"ZERO":     "0",
+		"MODE":     "fast",
+		"NAME":     "hello world",
+		"CITY":     "zürich",
+	}
+	lookup := func(k string) string { return vars[k] }
+
+	tests := []struct {
+		in      string
+		want    bool
+		wantErr bool
+	}{
+		{"ENABLED", true, false},
+		{"DISABLED", false, false},
+		{"ZERO", false, false},
+		{"UNSET", false, false},
+		{"MODE", true, false},
+		{"!DISABLED", true, false},
+		{"!!ENABLED", true, false},
+		{"MODE == fast", true, false},
+		{"MODE==fast", true, false},
+		{"MODE != fast", false, false},
+		{`NAME == "hello world"`, true, false},
+		{`NAME == 'hello world'`, true, false},
+		{"ENABLED && MODE == slow", false, false},
+		{"DISABLED || MODE == fast", true, false},
+		{"DISABLED || ENABLED && ZERO", false, false},
+		{"(DISABLED || ENABLED) && !ZERO", true, false},
+		{"", false, true},
+		{"MODE ==", false, true},
+		{"MODE == fast ENABLED", false, true},
+		{"(ENABLED", false, true},
+		{"ENABLED &&", false, true},
+		{`NAME == "unterminated`, false, true},
+		{"MODE = fast", false, true},
+		{"CITY == zürich", true, false},
+		{"CITY != zurich && !ÉTÉ", true, false},
+		{"CITY == ✓", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			w, err := parseWhen(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWhen(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := w.eval(lookup); got != tt.want {
+				t.Errorf("eval(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseWhen_unexpectedRune(t *testing.T) {
+	_, err := parseWhen("MODE == ✓")
+	if err == nil || !strings.Contains(err.Error(), `'✓'`) {
+		t.Fatalf("parseWhen() error = %v, want one showing '✓'", err)
+	}
+}
+
+func Test_whenExpr_refs(t *testing.T) {
+	w, err := parseWhen("A && (B == x || !C)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := w.refs(), []string{"A", "B", "C"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("refs() = %v, want %v", got, expected)
+	}
+}